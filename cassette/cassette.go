@@ -0,0 +1,193 @@
+// Package cassette records HTTP interactions to a file and replays them later, so that a run against the real Up API
+// can be repeated deterministically for auditing and regression testing
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// redacted replaces the values of sensitive headers in recorded requests
+const redacted = "REDACTED"
+
+var sensitiveHeaders = []string{"Authorization"}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// Recorder is an http.RoundTripper that passes requests through to another RoundTripper, recording every interaction.
+// Call Save once done to write the cassette out
+type Recorder struct {
+	path      string
+	transport http.RoundTripper
+
+	mtx      sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a Recorder that will save to path. If transport is nil, http.DefaultTransport is used
+func NewRecorder(path string, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &Recorder{path: path, transport: transport}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	header := req.Header.Clone()
+	for _, h := range sensitiveHeaders {
+		if header.Get(h) != "" {
+			header.Set(h, redacted)
+		}
+	}
+
+	r.mtx.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: header,
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       string(body),
+		},
+	})
+	r.mtx.Unlock()
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// Client returns an http.Client that records through r
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Save writes every interaction recorded so far to the cassette file
+func (r *Recorder) Save() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	body, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.WriteFile(r.path, body, 0o600); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+
+	return nil
+}
+
+// Replayer is an http.RoundTripper that serves responses from a cassette instead of making requests. Each recorded
+// interaction is served once, matched on method and URL, in the order it was recorded. Requests with nothing left to
+// match them fail
+type Replayer struct {
+	mtx  sync.Mutex
+	cass Cassette
+	used []bool
+}
+
+// Load reads a cassette file for replaying
+func Load(path string) (*Replayer, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cassette: %w", err)
+	}
+
+	return &Replayer{cass: c, used: make([]bool, len(c.Interactions))}, nil
+}
+
+func (p *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	url := req.URL.String()
+	for i, in := range p.cass.Interactions {
+		if p.used[i] || in.Request.Method != req.Method || in.Request.URL != url {
+			continue
+		}
+		p.used[i] = true
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(in.Response.Body))),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("cassette has no unplayed interaction for %s %s", req.Method, url)
+}
+
+// Client returns an http.Client that replays from p
+func (p *Replayer) Client() *http.Client {
+	return &http.Client{Transport: p}
+}
+
+// Unplayed returns the number of recorded interactions that haven't been replayed
+func (p *Replayer) Unplayed() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	n := 0
+	for _, used := range p.used {
+		if !used {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,61 @@
+package cassette
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+	"github.com/moskyb/upbank-fbar-calculator/upapitest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	srv.AddAccount(upapitest.Account("spending", "Spending", 100, time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)))
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecorder(path, nil)
+
+	c := srv.Client(upapi.WithHTTPClient(rec.Client()))
+	recorded, err := c.PaginateAllAccounts(context.Background(), upapi.ListAccountsParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := rec.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Nothing should need the server from here on
+	srv.Close()
+
+	rep, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if rep.cass.Interactions[0].Request.Header.Get("Authorization") != redacted {
+		t.Errorf("expected Authorization header to be redacted, got %q", rep.cass.Interactions[0].Request.Header.Get("Authorization"))
+	}
+
+	c = upapi.NewClient("any", upapi.WithQuiet(), upapi.WithHost(srv.URL), upapi.WithHTTPClient(rep.Client()), upapi.WithRetryPolicy(upapi.NoRetries))
+	replayed, err := c.PaginateAllAccounts(context.Background(), upapi.ListAccountsParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(replayed) != len(recorded) || replayed[0].ID != recorded[0].ID {
+		t.Errorf("expected replayed accounts %+v to match recorded accounts %+v", replayed, recorded)
+	}
+
+	if rep.Unplayed() != 0 {
+		t.Errorf("expected every interaction to have been played, %d weren't", rep.Unplayed())
+	}
+
+	_, err = c.PaginateAllAccounts(context.Background(), upapi.ListAccountsParams{})
+	if err == nil || !strings.Contains(err.Error(), "no unplayed interaction") {
+		t.Errorf("expected an unmatched request to fail, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// Exit codes for check, so that scripts can tell a bad token from Up being unreachable. They carry on from exitFailed
+// and exitUsage
+const (
+	exitNetworkFailure = 3
+	exitInvalidToken   = 4
+)
+
+// runCheck validates UP_TOKEN and lists the accounts it can see
+func runCheck(args []string) error {
+	fs := newFlagSet("check", "[flags]", "Checks that UP_TOKEN works and lists the accounts it can see. Exits with 0 if it does, 4 if Up rejects the\ntoken, 3 if Up can't be reached, 2 if UP_TOKEN isn't set or the flags are wrong, and 1 for anything else.")
+	var common commonFlags
+	common.register(fs)
+	fs.Parse(args)
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ping, err := client.Ping(ctx)
+	if err != nil {
+		return checkError(err)
+	}
+	fmt.Printf("%s UP_TOKEN is valid\n\n", ping.Meta.StatusEmoji)
+
+	accounts, err := client.PaginateAllAccounts(ctx, upapi.ListAccountsParams{})
+	if err != nil {
+		return checkError(err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tTYPE\tOWNERSHIP\tCREATED")
+	for _, acc := range accounts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", acc.Attributes.DisplayName, acc.Attributes.AccountType, acc.Attributes.OwnershipType, acc.Attributes.CreatedAt.Format(time.DateOnly))
+	}
+
+	return tw.Flush()
+}
+
+// checkError gives err the exit code for the kind of failure it was
+func checkError(err error) error {
+	// url.Error is a net.Error too, so this catches both failed connections and requests that never got a response
+	var netErr net.Error
+	switch {
+	case upapi.IsUnauthorized(err):
+		return exitError{err: err, code: exitInvalidToken}
+	case errors.Is(err, context.Canceled):
+		return err
+	case errors.As(err, &netErr):
+		return exitError{err: fmt.Errorf("couldn't reach Up, check your internet connection and try again: %w", err), code: exitNetworkFailure}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+func TestCheckError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{
+			name: "unauthorized",
+			err:  fmt.Errorf("failed to ping: %w", upapi.ErrUnauthorized),
+			code: exitInvalidToken,
+		},
+		{
+			name: "connection refused",
+			err:  fmt.Errorf("failed to make request: %w", &url.Error{Op: "Get", URL: "https://api.up.com.au/api/v1/util/ping", Err: syscall.ECONNREFUSED}),
+			code: exitNetworkFailure,
+		},
+		{
+			name: "interrupted",
+			err:  fmt.Errorf("failed to make request: %w", &url.Error{Op: "Get", URL: "https://api.up.com.au/api/v1/util/ping", Err: context.Canceled}),
+			code: exitFailed,
+		},
+		{
+			name: "bad response",
+			err:  fmt.Errorf("failed to decode response: %w", &json.SyntaxError{}),
+			code: exitFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := exitFailed
+			var eErr exitError
+			if errors.As(checkError(tt.err), &eErr) {
+				code = eErr.code
+			}
+
+			if code != tt.code {
+				t.Errorf("expected exit code %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
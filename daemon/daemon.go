@@ -0,0 +1,410 @@
+// Package daemon keeps every account's ledger up to date as transactions happen, so that FBAR exposure can be watched
+// during the year instead of being reconstructed after it
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/ledger"
+	"github.com/moskyb/upbank-fbar-calculator/store"
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// DefaultPollInterval is how often every account is synced if WithPollInterval isn't given
+const DefaultPollInterval = 15 * time.Minute
+
+type config struct {
+	pollInterval  time.Duration
+	location      *time.Location
+	exchangeRates *fbar.ExchangeRates
+	ledgerOpts    []ledger.Option
+	webhookSecret string
+	logger        *slog.Logger
+	now           func() time.Time
+}
+
+// Option configures a Daemon
+type Option func(*config)
+
+// WithPollInterval sets how often every account is synced. Zero turns polling off, which only makes sense alongside
+// WithWebhookSecret
+func WithPollInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.pollInterval = d
+	}
+}
+
+// WithLocation sets the timezone that decides which year it is, and which year transactions fall in. It defaults to
+// Australia/Sydney, as for fbar.GenerateReport
+func WithLocation(loc *time.Location) Option {
+	return func(c *config) {
+		c.location = loc
+	}
+}
+
+// WithExchangeRates sets the rate table used to estimate USD values. The latest rate in it is used, since the
+// year-end rate for the current year doesn't exist yet
+func WithExchangeRates(rates *fbar.ExchangeRates) Option {
+	return func(c *config) {
+		c.exchangeRates = rates
+	}
+}
+
+// WithLedgerOptions passes options through to the ledger built for each account
+func WithLedgerOptions(opts ...ledger.Option) Option {
+	return func(c *config) {
+		c.ledgerOpts = append(c.ledgerOpts, opts...)
+	}
+}
+
+// WithWebhookSecret accepts Up webhook deliveries signed with the given secret key at /webhook, and syncs the affected
+// account as soon as an event arrives. Events are only acted on while Run is running
+func WithWebhookSecret(secret string) Option {
+	return func(c *config) {
+		c.webhookSecret = secret
+	}
+}
+
+// WithLogger sets where sync failures and progress are logged. The default is slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// Daemon holds a live ledger for every account, kept current by polling Up and by webhook events
+type Daemon struct {
+	client *upapi.Client
+	store  *store.Store
+	cfg    config
+	rate   *fbar.ExchangeRate
+
+	// syncMtx makes sure only one sync touches the store at a time, since Sync and SyncAccount can be called alongside Run
+	syncMtx sync.Mutex
+
+	// queueMtx guards the syncs queued by webhook events, which Run works through. queueReady is signalled whenever
+	// something is queued
+	queueMtx    sync.Mutex
+	queuedAll   bool
+	queuedAccts map[string]bool
+	queuedXacts map[string]bool
+	queueReady  chan struct{}
+
+	mtx      sync.RWMutex
+	accounts map[string]*account
+}
+
+type account struct {
+	upapi.Account
+	ledger     *ledger.Ledger
+	lastSynced time.Time
+}
+
+// New returns a daemon that syncs transactions into s using client. Nothing is fetched until Run or Sync is called
+func New(client *upapi.Client, s *store.Store, opts ...Option) (*Daemon, error) {
+	cfg := config{
+		pollInterval: DefaultPollInterval,
+		logger:       slog.Default(),
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.location == nil {
+		loc, err := time.LoadLocation("Australia/Sydney")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Sydney timezone: %w", err)
+		}
+		cfg.location = loc
+	}
+	cfg.ledgerOpts = append(cfg.ledgerOpts, ledger.WithLocation(cfg.location))
+
+	if cfg.exchangeRates == nil {
+		rates, err := fbar.DefaultExchangeRates()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load exchange rates: %w", err)
+		}
+		cfg.exchangeRates = rates
+	}
+
+	d := &Daemon{
+		client:      client,
+		store:       s,
+		cfg:         cfg,
+		accounts:    make(map[string]*account),
+		queuedAccts: make(map[string]bool),
+		queuedXacts: make(map[string]bool),
+		queueReady:  make(chan struct{}, 1),
+	}
+
+	if rate, err := cfg.exchangeRates.LatestRate(fbar.CurrencyAUD); err == nil {
+		d.rate = &rate
+	} else {
+		cfg.logger.Warn("no exchange rate available, USD values won't be estimated", "error", err)
+	}
+
+	return d, nil
+}
+
+// Run syncs every account, then keeps syncing them every poll interval, and whenever webhook events queue a sync,
+// until ctx is cancelled. Failures after the first sync are logged rather than returned, so that a blip at Up doesn't
+// stop the daemon
+func (d *Daemon) Run(ctx context.Context) error {
+	if err := d.Sync(ctx); err != nil {
+		return fmt.Errorf("failed initial sync: %w", err)
+	}
+
+	var tick <-chan time.Time
+	if d.cfg.pollInterval > 0 {
+		ticker := time.NewTicker(d.cfg.pollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			if err := d.Sync(ctx); err != nil && ctx.Err() == nil {
+				d.cfg.logger.Error("failed to sync accounts", "error", err)
+			}
+		case <-d.queueReady:
+			if err := d.syncQueued(ctx); err != nil && ctx.Err() == nil {
+				d.cfg.logger.Error("failed to sync accounts for webhook events", "error", err)
+			}
+		}
+	}
+}
+
+// Sync fetches the account list and brings every account's ledger up to date. Accounts that fail to sync keep their
+// previous ledger
+func (d *Daemon) Sync(ctx context.Context) error {
+	accounts, err := d.client.PaginateAllAccounts(ctx, upapi.ListAccountsParams{})
+	if err != nil {
+		return fmt.Errorf("failed to list accounts: %w", err)
+	}
+
+	var errs []error
+	for _, acc := range accounts {
+		if err := d.syncAccount(ctx, acc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SyncAccount brings a single account's ledger up to date, adding the account if it's new
+func (d *Daemon) SyncAccount(ctx context.Context, accountID string) error {
+	resp, err := d.client.GetAccount(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to get account %s: %w", accountID, err)
+	}
+
+	return d.syncAccount(ctx, resp.Data)
+}
+
+func (d *Daemon) syncAccount(ctx context.Context, acc upapi.Account) error {
+	d.syncMtx.Lock()
+	defer d.syncMtx.Unlock()
+
+	xacts, err := d.store.Sync(ctx, d.client, acc.ID)
+	if err != nil {
+		return fmt.Errorf("failed to sync transactions for account %s: %w", acc.ID, err)
+	}
+
+	l := ledger.FromTransactions(acc.Attributes.DisplayName, xacts, d.cfg.ledgerOpts...)
+
+	d.mtx.Lock()
+	d.accounts[acc.ID] = &account{Account: acc, ledger: l, lastSynced: d.cfg.now()}
+	d.mtx.Unlock()
+
+	d.cfg.logger.Debug("synced account", "account", acc.Attributes.DisplayName, "transactions", len(xacts))
+
+	return nil
+}
+
+// HandleEvent queues a sync of whichever account a webhook event affects, for Run to pick up, and returns straight
+// away so that Up isn't kept waiting. Events that arrive before the queue is worked through are collapsed, so each
+// account is only synced once. Deleted transactions can't be looked up any more, so if the daemon hasn't seen the
+// transaction before, every account is synced
+func (d *Daemon) HandleEvent(ctx context.Context, event upapi.WebhookEvent) error {
+	xactID := event.TransactionID()
+
+	accountID, known := "", false
+	if event.Attributes.EventType == upapi.WebhookEventTypeTransactionDeleted {
+		accountID, known = d.accountFor(xactID)
+	}
+
+	d.queueMtx.Lock()
+	switch {
+	case known:
+		d.queuedAccts[accountID] = true
+	case event.Attributes.EventType == upapi.WebhookEventTypeTransactionDeleted:
+		d.queuedAll = true
+	default:
+		d.queuedXacts[xactID] = true
+	}
+	d.queueMtx.Unlock()
+
+	select {
+	case d.queueReady <- struct{}{}:
+	default:
+		// Run has already been told there's something queued
+	}
+
+	return nil
+}
+
+// syncQueued takes everything queued by HandleEvent and syncs the accounts it affects
+func (d *Daemon) syncQueued(ctx context.Context) error {
+	d.queueMtx.Lock()
+	all, accountIDs, xactIDs := d.queuedAll, d.queuedAccts, d.queuedXacts
+	d.queuedAll, d.queuedAccts, d.queuedXacts = false, make(map[string]bool), make(map[string]bool)
+	d.queueMtx.Unlock()
+
+	if all {
+		return d.Sync(ctx)
+	}
+
+	var errs []error
+	for _, xactID := range slices.Sorted(maps.Keys(xactIDs)) {
+		resp, err := d.client.GetTransaction(ctx, xactID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get transaction %s: %w", xactID, err))
+			continue
+		}
+		accountIDs[resp.Data.AccountID()] = true
+	}
+
+	for _, accountID := range slices.Sorted(maps.Keys(accountIDs)) {
+		if err := d.SyncAccount(ctx, accountID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// accountFor finds the account holding a transaction the daemon has already seen
+func (d *Daemon) accountFor(xactID string) (string, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	for id, acc := range d.accounts {
+		if slices.ContainsFunc(acc.ledger.Entries, func(e ledger.Entry) bool { return e.ID == xactID }) {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// AccountStatus is where an account stands so far this year
+type AccountStatus struct {
+	ID          string       `json:"id"`
+	DisplayName string       `json:"display_name"`
+	AccountType string       `json:"account_type"`
+	Ownership   string       `json:"ownership"`
+	Balance     ledger.Money `json:"balance"`
+
+	// HighWaterMark is the highest balance so far this year, including the balance carried into it
+	HighWaterMark ledger.Money `json:"high_water_mark"`
+	// HighWaterMarkUSD is an estimate using the latest exchange rate, since the year-end rate isn't known yet
+	HighWaterMarkUSD int `json:"high_water_mark_usd"`
+
+	// Discrepancy is how far the reconstructed balance is from the one Up reports, which should be zero
+	Discrepancy ledger.Money `json:"discrepancy"`
+	HeldCount   int          `json:"held_count"`
+	LastSynced  time.Time    `json:"last_synced"`
+}
+
+// Summary is the household's FBAR exposure so far this year
+type Summary struct {
+	Year     int             `json:"year"`
+	Accounts []AccountStatus `json:"accounts"`
+
+	AggregateUSD   int    `json:"aggregate_usd"`
+	ThresholdUSD   int    `json:"threshold_usd"`
+	FilingRequired bool   `json:"filing_required"`
+	ExchangeRate   string `json:"exchange_rate,omitempty"`
+}
+
+// Status returns the current state of a single account, and whether the daemon knows about it
+func (d *Daemon) Status(accountID string) (AccountStatus, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	acc, ok := d.accounts[accountID]
+	if !ok {
+		return AccountStatus{}, false
+	}
+
+	return d.status(acc, d.year()), true
+}
+
+// Summary returns the current state of every account, sorted by ID, and whether they've crossed the FBAR threshold
+func (d *Daemon) Summary() Summary {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	s := Summary{Year: d.year(), ThresholdUSD: fbar.FilingThresholdUSD, Accounts: []AccountStatus{}}
+	if d.rate != nil {
+		s.ExchangeRate = d.rate.String()
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(d.accounts)) {
+		status := d.status(d.accounts[id], s.Year)
+		s.Accounts = append(s.Accounts, status)
+		if status.HighWaterMarkUSD > 0 {
+			s.AggregateUSD += status.HighWaterMarkUSD
+		}
+	}
+
+	// As for fbar.Report.EvaluateThreshold, filing is required when the aggregate is *more than* the threshold
+	s.FilingRequired = s.AggregateUSD > s.ThresholdUSD
+
+	return s
+}
+
+func (d *Daemon) year() int {
+	return d.cfg.now().In(d.cfg.location).Year()
+}
+
+func (d *Daemon) status(acc *account, year int) AccountStatus {
+	balance := acc.Attributes.Balance.ValueInBaseUnits
+
+	discrepancy := 0
+	var rErr *ledger.ReconciliationError
+	if err := acc.ledger.Reconcile(balance); errors.As(err, &rErr) {
+		discrepancy = rErr.Offset()
+	}
+
+	hwm := acc.ledger.HighWaterMark(year)
+	status := AccountStatus{
+		ID:            acc.ID,
+		DisplayName:   acc.Attributes.DisplayName,
+		AccountType:   acc.Attributes.AccountType,
+		Ownership:     acc.Attributes.OwnershipType,
+		Balance:       ledger.Money(balance),
+		HighWaterMark: ledger.Money(hwm),
+		Discrepancy:   ledger.Money(discrepancy),
+		HeldCount:     len(acc.ledger.Held),
+		LastSynced:    acc.lastSynced,
+	}
+	if d.rate != nil {
+		status.HighWaterMarkUSD = d.rate.ToUSD(hwm)
+	}
+
+	return status
+}
@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/store"
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+	"github.com/moskyb/upbank-fbar-calculator/upapitest"
+)
+
+func TestDaemon(t *testing.T) {
+	const secret = "shh"
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	defer srv.Close()
+
+	srv.AddAccount(upapitest.Account("spending", "Spending", 500_00, now.AddDate(-2, 0, 0)),
+		upapitest.Transaction("1", "Salary", 1_000_00, now.AddDate(-1, 0, 0)),
+		upapitest.Transaction("2", "Rent", -500_00, now.AddDate(0, -1, 0)),
+	)
+
+	s, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d, err := New(srv.Client(), s, WithPollInterval(0), WithLocation(time.UTC), WithWebhookSecret(secret), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.cfg.now = func() time.Time { return now }
+
+	if err := d.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	api := httptest.NewServer(d.Handler())
+	defer api.Close()
+
+	// Webhook events are only synced while the daemon is running
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	status := func() AccountStatus {
+		resp, err := http.Get(api.URL + "/accounts/spending")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var status AccountStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return status
+	}
+
+	if got := status(); got.HighWaterMark != 1_000_00 || got.Discrepancy != 0 {
+		t.Errorf("expected a high water mark of 1000.00 carried into the year with no discrepancy, got %+v", got)
+	}
+
+	deliver := func(eventType, xactID string) {
+		body := fmt.Sprintf(`{"data": {"type": "webhook-events", "id": "event", "attributes": {"eventType": %q, "createdAt": %q}, "relationships": {"webhook": {"data": {"type": "webhooks", "id": "hook"}}, "transaction": {"data": {"type": "transactions", "id": %q}}}}}`,
+			eventType, now.Format(time.RFC3339), xactID)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+
+		req, err := http.NewRequest(http.MethodPost, api.URL+"/webhook", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req.Header.Set(upapi.WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected the webhook delivery to be accepted, got %d", resp.StatusCode)
+		}
+	}
+
+	// eventually waits for the daemon to sync in the background
+	eventually := func(cond func() bool) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+
+	srv.AddTransactions("spending", upapitest.Transaction("3", "Bonus", 2_000_00, now.Add(-time.Hour)))
+	deliver(upapi.WebhookEventTypeTransactionCreated, "3")
+
+	if !eventually(func() bool { return status().HighWaterMark == 2_500_00 }) {
+		t.Errorf("expected the new transaction to raise the high water mark to 2500.00, got %+v", status())
+	}
+
+	if summary := d.Summary(); summary.Year != 2024 || len(summary.Accounts) != 1 || summary.AggregateUSD == 0 {
+		t.Errorf("expected a 2024 summary of one account with a USD estimate, got %+v", summary)
+	}
+
+	// The daemon has never seen this transaction, so it can't tell which account it was deleted from and syncs them
+	// all, which picks up the new account
+	srv.AddAccount(upapitest.Account("saver", "Saver", 100_00, now.AddDate(-1, 0, 0)),
+		upapitest.Transaction("4", "Interest", 100_00, now.AddDate(0, -2, 0)),
+	)
+	deliver(upapi.WebhookEventTypeTransactionDeleted, "unknown")
+
+	if !eventually(func() bool { return len(d.Summary().Accounts) == 2 }) {
+		t.Errorf("expected a deleted event for an unknown transaction to sync every account, got %+v", d.Summary())
+	}
+}
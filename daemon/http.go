@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// Handler serves the daemon's JSON API:
+//
+//	GET /summary         every account, and whether they've crossed the FBAR threshold so far this year
+//	GET /accounts        every account's status
+//	GET /accounts/{id}   a single account's status
+//	POST /webhook        Up webhook deliveries, if WithWebhookSecret was given
+//
+// It has no authentication of its own, so it should only be listened on locally
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /summary", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.Summary())
+	})
+	mux.HandleFunc("GET /accounts", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.Summary().Accounts)
+	})
+	mux.HandleFunc("GET /accounts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		status, ok := d.Status(r.PathValue("id"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "account not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+
+	if d.cfg.webhookSecret != "" {
+		h := upapi.NewWebhookHandler(d.cfg.webhookSecret, d.HandleEvent)
+		h.Logger = d.cfg.logger
+		mux.Handle("POST /webhook", h)
+	}
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,486 @@
+package fbar
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// Codes from the FinCEN FBAR XML Schema User Guide
+const (
+	partyTypeTransmitter        = "35"
+	partyTypeTransmitterContact = "37"
+	partyTypeFiler              = "15"
+	partyTypeFinancialInst      = "41"
+	partyTypePrincipalJoint     = "42"
+
+	eFilingAccountTypeSeparate = "141"
+	eFilingAccountTypeJoint    = "142"
+
+	accountTypeBank = "1"
+
+	partyNameTypeLegal = "L"
+
+	identificationTypeSSN     = "1"
+	identificationTypeEIN     = "2"
+	identificationTypeForeign = "9"
+	identificationTypeTCC     = "28"
+)
+
+// Up accounts are held with Bendigo and Adelaide Bank, which is the institution that goes on the FBAR and Form 8938
+const (
+	UpInstitutionName    = "Bendigo and Adelaide Bank Limited"
+	UpInstitutionStreet  = "The Bendigo Centre, 22-44 Bath Lane"
+	UpInstitutionCity    = "Bendigo"
+	UpInstitutionState   = "VIC"
+	UpInstitutionZIP     = "3550"
+	UpInstitutionCountry = "AU"
+)
+
+type FilerType string
+
+const (
+	FilerTypeIndividual  FilerType = "individual"
+	FilerTypeCorporation FilerType = "corporation"
+	FilerTypePartnership FilerType = "partnership"
+	FilerTypeFiduciary   FilerType = "fiduciary"
+)
+
+type TINType string
+
+const (
+	TINTypeSSN     TINType = "ssn" // also covers ITINs
+	TINTypeEIN     TINType = "ein"
+	TINTypeForeign TINType = "foreign"
+)
+
+type Address struct {
+	Street  string `json:"street"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	ZIP     string `json:"zip"`
+	Country string `json:"country"` // two letter ISO code
+}
+
+// FilerProfile is everything about the filer that the FBAR needs but that Up doesn't know
+type FilerProfile struct {
+	Type FilerType `json:"type"`
+
+	// Individuals fill in the name parts, everyone else uses EntityName
+	FirstName  string `json:"first_name"`
+	MiddleName string `json:"middle_name"`
+	LastName   string `json:"last_name"`
+	EntityName string `json:"entity_name"`
+
+	TIN       string    `json:"tin"`
+	TINType   TINType   `json:"tin_type"`
+	BirthDate time.Time `json:"birth_date"`
+	Address   Address   `json:"address"`
+
+	// TransmitterName is who the TCC was issued to, and TransmitterContactName is who FinCEN should contact about the
+	// filing. Both are usually the filer when filing for yourself, but not when a preparer files for you
+	TransmitterName        string `json:"transmitter_name"`
+	TransmitterContactName string `json:"transmitter_contact_name"`
+	// TransmitterControlCode is the TCC issued by FinCEN for batch filing
+	TransmitterControlCode string `json:"transmitter_control_code"`
+	ContactPhone           string `json:"contact_phone"`
+
+	SignatureDate time.Time `json:"signature_date"`
+
+	// AccountNumbers maps account display names to their account numbers, which the Up API doesn't expose
+	AccountNumbers map[string]string `json:"account_numbers"`
+	// JointOwners maps the display names of joint accounts to the name of the other owner, which every joint account
+	// on the FBAR needs
+	JointOwners map[string]string `json:"joint_owners"`
+}
+
+func LoadFilerProfileFile(path string) (*FilerProfile, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filer profile: %w", err)
+	}
+
+	var p FilerProfile
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal filer profile: %w", err)
+	}
+
+	return &p, nil
+}
+
+var nineDigitsRE = regexp.MustCompile(`^\d{9}$`)
+
+// Validate checks that the profile has everything required to file an FBAR for the given report
+func (p FilerProfile) Validate(r *Report) error {
+	var errs []error
+	missing := func(field string) {
+		errs = append(errs, fmt.Errorf("filer profile is missing %s", field))
+	}
+
+	switch p.Type {
+	case FilerTypeIndividual:
+		if p.FirstName == "" {
+			missing("first_name")
+		}
+		if p.LastName == "" {
+			missing("last_name")
+		}
+		if p.BirthDate.IsZero() {
+			missing("birth_date")
+		}
+	case FilerTypeCorporation, FilerTypePartnership, FilerTypeFiduciary:
+		if p.EntityName == "" {
+			missing("entity_name")
+		}
+	case "":
+		missing("type")
+	default:
+		errs = append(errs, fmt.Errorf("unknown filer type %q", p.Type))
+	}
+
+	switch p.TINType {
+	case TINTypeSSN, TINTypeEIN:
+		if !nineDigitsRE.MatchString(p.TIN) {
+			errs = append(errs, fmt.Errorf("TIN must be nine digits with no dashes"))
+		}
+	case TINTypeForeign:
+		if p.TIN == "" {
+			missing("tin")
+		}
+	case "":
+		missing("tin_type")
+	default:
+		errs = append(errs, fmt.Errorf("unknown TIN type %q", p.TINType))
+	}
+
+	if p.Address.Street == "" {
+		missing("address.street")
+	}
+	if p.Address.City == "" {
+		missing("address.city")
+	}
+	if len(p.Address.Country) != 2 {
+		errs = append(errs, fmt.Errorf("address.country must be a two letter country code"))
+	}
+	if p.Address.Country == "US" {
+		if p.Address.State == "" {
+			missing("address.state")
+		}
+		if p.Address.ZIP == "" {
+			missing("address.zip")
+		}
+	}
+
+	if p.TransmitterName == "" {
+		missing("transmitter_name")
+	}
+	if p.TransmitterContactName == "" {
+		missing("transmitter_contact_name")
+	}
+	if p.TransmitterControlCode == "" {
+		missing("transmitter_control_code")
+	}
+	if p.ContactPhone == "" {
+		missing("contact_phone")
+	}
+	if p.SignatureDate.IsZero() {
+		missing("signature_date")
+	}
+
+	if len(r.Entries) == 0 {
+		errs = append(errs, fmt.Errorf("report has no accounts to file"))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(r.Entries)) {
+		entry := r.Entries[name]
+		if p.AccountNumbers[name] == "" {
+			errs = append(errs, fmt.Errorf("filer profile is missing an account number for %q", name))
+		}
+
+		switch entry.Ownership {
+		case upapi.OwnershipTypeIndividual:
+		case upapi.OwnershipTypeJoint:
+			if p.JointOwners[name] == "" {
+				errs = append(errs, fmt.Errorf("filer profile is missing a joint owner for %q", name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("account %q has unknown ownership type %q", name, entry.Ownership))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WriteFBARXML validates the profile against the report, then writes the report as a FinCEN Form 114 batch XML
+// document suitable for uploading to BSA E-Filing. Nothing is written if validation fails
+func (r *Report) WriteFBARXML(w io.Writer, p FilerProfile) error {
+	if err := p.Validate(r); err != nil {
+		return fmt.Errorf("invalid FBAR: %w", err)
+	}
+
+	doc := r.fbarXML(p)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write XML header: %w", err)
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to marshal XML: %w", err)
+	}
+
+	return enc.Close()
+}
+
+type xmlBatch struct {
+	XMLName           xml.Name    `xml:"fc2:EFilingBatchXML"`
+	Namespace         string      `xml:"xmlns:fc2,attr"`
+	XSINamespace      string      `xml:"xmlns:xsi,attr"`
+	SchemaLocation    string      `xml:"xsi:schemaLocation,attr"`
+	ActivityCount     int         `xml:"ActivityCount,attr"`
+	TotalAccountCount int         `xml:"TotalAccountCount,attr"`
+	FormTypeCode      string      `xml:"fc2:FormTypeCode"`
+	Activity          xmlActivity `xml:"fc2:Activity"`
+}
+
+type xmlActivity struct {
+	SeqNum                 int                       `xml:"SeqNum,attr"`
+	SignatureDate          string                    `xml:"fc2:ApprovalOfficialSignatureDateText"`
+	Association            xmlActivityAssociation    `xml:"fc2:ActivityAssociation"`
+	Parties                []xmlParty                `xml:"fc2:Party"`
+	Accounts               []xmlAccount              `xml:"fc2:Account"`
+	ForeignAccountActivity xmlForeignAccountActivity `xml:"fc2:ForeignAccountActivity"`
+}
+
+type xmlActivityAssociation struct {
+	SeqNum                  int    `xml:"SeqNum,attr"`
+	CorrectsAmendsIndicator string `xml:"fc2:CorrectsAmendsPriorReportIndicator,omitempty"`
+}
+
+type xmlForeignAccountActivity struct {
+	SeqNum           int    `xml:"SeqNum,attr"`
+	CalendarYearText string `xml:"fc2:ReportCalendarYearText"`
+}
+
+type xmlParty struct {
+	SeqNum        int    `xml:"SeqNum,attr"`
+	PartyTypeCode string `xml:"fc2:ActivityPartyTypeCode"`
+
+	FilerTypeIndividual  string `xml:"fc2:FilerTypeIndividualIndicator,omitempty"`
+	FilerTypeCorporation string `xml:"fc2:FilerTypeCorporationIndicator,omitempty"`
+	FilerTypePartnership string `xml:"fc2:FilerTypePartnershipIndicator,omitempty"`
+	FilerTypeFiduciary   string `xml:"fc2:FilerTypeFiduciaryOtherIndicator,omitempty"`
+
+	Name           *xmlPartyName           `xml:"fc2:PartyName,omitempty"`
+	Address        *xmlAddress             `xml:"fc2:Address,omitempty"`
+	PhoneNumber    *xmlPhoneNumber         `xml:"fc2:PhoneNumber,omitempty"`
+	Identification *xmlPartyIdentification `xml:"fc2:PartyIdentification,omitempty"`
+	Individual     *xmlIndividual          `xml:"fc2:Individual,omitempty"`
+}
+
+type xmlPartyName struct {
+	SeqNum     int    `xml:"SeqNum,attr"`
+	TypeCode   string `xml:"fc2:PartyNameTypeCode"`
+	FullName   string `xml:"fc2:RawPartyFullName,omitempty"`
+	LastName   string `xml:"fc2:RawEntityIndividualLastName,omitempty"`
+	FirstName  string `xml:"fc2:RawIndividualFirstName,omitempty"`
+	MiddleName string `xml:"fc2:RawIndividualMiddleName,omitempty"`
+}
+
+type xmlAddress struct {
+	SeqNum  int    `xml:"SeqNum,attr"`
+	City    string `xml:"fc2:RawCityText"`
+	Country string `xml:"fc2:RawCountryCodeText"`
+	State   string `xml:"fc2:RawStateCodeText,omitempty"`
+	Street  string `xml:"fc2:RawStreetAddress1Text"`
+	ZIP     string `xml:"fc2:RawZIPCode,omitempty"`
+}
+
+type xmlPhoneNumber struct {
+	SeqNum int    `xml:"SeqNum,attr"`
+	Number string `xml:"fc2:PhoneNumberText"`
+}
+
+type xmlPartyIdentification struct {
+	SeqNum   int    `xml:"SeqNum,attr"`
+	Number   string `xml:"fc2:PartyIdentificationNumberText"`
+	TypeCode string `xml:"fc2:PartyIdentificationTypeCode"`
+}
+
+type xmlIndividual struct {
+	SeqNum    int    `xml:"SeqNum,attr"`
+	BirthDate string `xml:"fc2:IndividualBirthDateText"`
+}
+
+type xmlAccount struct {
+	SeqNum                 int        `xml:"SeqNum,attr"`
+	MaximumValue           string     `xml:"fc2:AccountMaximumValueAmountText"`
+	AccountNumber          string     `xml:"fc2:AccountNumberText"`
+	AccountTypeCode        string     `xml:"fc2:AccountTypeCode"`
+	EFilingAccountTypeCode string     `xml:"fc2:EFilingAccountTypeCode"`
+	JointOwnerQuantity     string     `xml:"fc2:JointOwnerQuantityText,omitempty"`
+	Parties                []xmlParty `xml:"fc2:Party"`
+}
+
+const fbarDateFormat = "20060102"
+
+func (r *Report) fbarXML(p FilerProfile) xmlBatch {
+	seq := 0
+	next := func() int {
+		seq++
+		return seq
+	}
+
+	doc := xmlBatch{
+		Namespace:      "www.fincen.gov/base",
+		XSINamespace:   "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "www.fincen.gov/base https://www.fincen.gov/base/EFL_FBARXBatchSchema.xsd",
+		ActivityCount:  1,
+		FormTypeCode:   "FBARX",
+	}
+
+	act := xmlActivity{
+		SeqNum:        next(),
+		SignatureDate: p.SignatureDate.Format(fbarDateFormat),
+	}
+	act.Association = xmlActivityAssociation{SeqNum: next()}
+
+	act.Parties = append(act.Parties,
+		xmlParty{
+			SeqNum:        next(),
+			PartyTypeCode: partyTypeTransmitter,
+			Name:          &xmlPartyName{SeqNum: next(), TypeCode: partyNameTypeLegal, FullName: p.TransmitterName},
+			Address:       p.xmlAddress(next()),
+			PhoneNumber:   &xmlPhoneNumber{SeqNum: next(), Number: p.ContactPhone},
+			Identification: &xmlPartyIdentification{
+				SeqNum:   next(),
+				Number:   p.TransmitterControlCode,
+				TypeCode: identificationTypeTCC,
+			},
+		},
+		xmlParty{
+			SeqNum:        next(),
+			PartyTypeCode: partyTypeTransmitterContact,
+			Name:          &xmlPartyName{SeqNum: next(), TypeCode: partyNameTypeLegal, FullName: p.TransmitterContactName},
+		},
+		p.xmlFiler(next),
+	)
+
+	for _, name := range slices.Sorted(maps.Keys(r.Entries)) {
+		entry := r.Entries[name]
+
+		acc := xmlAccount{
+			SeqNum:                 next(),
+			MaximumValue:           strconv.Itoa(entry.HighWaterMarkUSD),
+			AccountNumber:          p.AccountNumbers[name],
+			AccountTypeCode:        accountTypeBank,
+			EFilingAccountTypeCode: eFilingAccountTypeSeparate,
+		}
+
+		acc.Parties = append(acc.Parties, xmlParty{
+			SeqNum:        next(),
+			PartyTypeCode: partyTypeFinancialInst,
+			Name:          &xmlPartyName{SeqNum: next(), TypeCode: partyNameTypeLegal, FullName: UpInstitutionName},
+			Address: &xmlAddress{
+				SeqNum:  next(),
+				City:    UpInstitutionCity,
+				Country: UpInstitutionCountry,
+				State:   UpInstitutionState,
+				Street:  UpInstitutionStreet,
+				ZIP:     UpInstitutionZIP,
+			},
+		})
+
+		if entry.Ownership == upapi.OwnershipTypeJoint {
+			acc.EFilingAccountTypeCode = eFilingAccountTypeJoint
+			// Up only supports two owners on a joint account
+			acc.JointOwnerQuantity = "2"
+
+			acc.Parties = append(acc.Parties, xmlParty{
+				SeqNum:        next(),
+				PartyTypeCode: partyTypePrincipalJoint,
+				Name:          &xmlPartyName{SeqNum: next(), TypeCode: partyNameTypeLegal, FullName: p.JointOwners[name]},
+			})
+		}
+
+		act.Accounts = append(act.Accounts, acc)
+	}
+
+	act.ForeignAccountActivity = xmlForeignAccountActivity{
+		SeqNum:           next(),
+		CalendarYearText: strconv.Itoa(r.FinancialYear),
+	}
+
+	doc.Activity = act
+	doc.TotalAccountCount = len(act.Accounts)
+
+	return doc
+}
+
+func (p FilerProfile) xmlFiler(next func() int) xmlParty {
+	filer := xmlParty{
+		SeqNum:        next(),
+		PartyTypeCode: partyTypeFiler,
+	}
+
+	switch p.Type {
+	case FilerTypeIndividual:
+		filer.FilerTypeIndividual = "Y"
+		filer.Name = &xmlPartyName{
+			SeqNum:     next(),
+			TypeCode:   partyNameTypeLegal,
+			LastName:   p.LastName,
+			FirstName:  p.FirstName,
+			MiddleName: p.MiddleName,
+		}
+	case FilerTypeCorporation:
+		filer.FilerTypeCorporation = "Y"
+	case FilerTypePartnership:
+		filer.FilerTypePartnership = "Y"
+	case FilerTypeFiduciary:
+		filer.FilerTypeFiduciary = "Y"
+	}
+
+	if filer.Name == nil {
+		filer.Name = &xmlPartyName{SeqNum: next(), TypeCode: partyNameTypeLegal, LastName: p.EntityName}
+	}
+
+	filer.Address = p.xmlAddress(next())
+
+	idType := identificationTypeSSN
+	switch p.TINType {
+	case TINTypeEIN:
+		idType = identificationTypeEIN
+	case TINTypeForeign:
+		idType = identificationTypeForeign
+	}
+	filer.Identification = &xmlPartyIdentification{SeqNum: next(), Number: p.TIN, TypeCode: idType}
+
+	if p.Type == FilerTypeIndividual {
+		filer.Individual = &xmlIndividual{SeqNum: next(), BirthDate: p.BirthDate.Format(fbarDateFormat)}
+	}
+
+	return filer
+}
+
+func (p FilerProfile) xmlAddress(seq int) *xmlAddress {
+	return &xmlAddress{
+		SeqNum:  seq,
+		City:    p.Address.City,
+		Country: p.Address.Country,
+		State:   p.Address.State,
+		Street:  p.Address.Street,
+		ZIP:     p.Address.ZIP,
+	}
+}
@@ -0,0 +1,101 @@
+package fbar
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+func testReport() *Report {
+	return &Report{
+		FinancialYear: 2023,
+		Entries: map[string]ReportEntry{
+			"Spending": {AccountName: "Spending", Ownership: upapi.OwnershipTypeIndividual, HighWaterMarkUSD: 1234},
+			"Together": {AccountName: "Together", Ownership: upapi.OwnershipTypeJoint, HighWaterMarkUSD: 5678},
+		},
+	}
+}
+
+func testProfile() FilerProfile {
+	return FilerProfile{
+		Type:      FilerTypeIndividual,
+		FirstName: "Jane",
+		LastName:  "Citizen",
+		TIN:       "123456789",
+		TINType:   TINTypeSSN,
+		BirthDate: time.Date(1990, time.January, 2, 0, 0, 0, 0, time.UTC),
+		Address: Address{
+			Street:  "1 Example St",
+			City:    "Melbourne",
+			State:   "VIC",
+			ZIP:     "3000",
+			Country: "AU",
+		},
+		TransmitterName:        "Example Tax Services",
+		TransmitterContactName: "Sam Preparer",
+		TransmitterControlCode: "TBSATEST",
+		ContactPhone:           "0400000000",
+		SignatureDate:          time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		AccountNumbers: map[string]string{
+			"Spending": "111111111",
+			"Together": "222222222",
+		},
+		JointOwners: map[string]string{"Together": "John Citizen"},
+	}
+}
+
+func TestWriteFBARXML(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := testReport().WriteFBARXML(buf, testProfile()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := buf.String()
+	if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+		t.Fatalf("expected output to be well-formed XML, got error: %v", err)
+	}
+
+	for _, want := range []string{
+		`<fc2:EFilingBatchXML xmlns:fc2="www.fincen.gov/base"`,
+		`TotalAccountCount="2"`,
+		`<fc2:AccountMaximumValueAmountText>1234</fc2:AccountMaximumValueAmountText>`,
+		`<fc2:EFilingAccountTypeCode>142</fc2:EFilingAccountTypeCode>`,
+		`<fc2:ReportCalendarYearText>2023</fc2:ReportCalendarYearText>`,
+		`<fc2:IndividualBirthDateText>19900102</fc2:IndividualBirthDateText>`,
+		`<fc2:RawPartyFullName>Example Tax Services</fc2:RawPartyFullName>`,
+		`<fc2:RawPartyFullName>Sam Preparer</fc2:RawPartyFullName>`,
+		`<fc2:RawPartyFullName>John Citizen</fc2:RawPartyFullName>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %s, but it didn't:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteFBARXMLValidates(t *testing.T) {
+	p := testProfile()
+	p.TIN = "123-45-6789"
+	delete(p.AccountNumbers, "Together")
+	p.TransmitterName = ""
+	p.JointOwners = nil
+
+	buf := &bytes.Buffer{}
+	err := testReport().WriteFBARXML(buf, p)
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+
+	for _, want := range []string{"TIN must be nine digits", `account number for "Together"`, "transmitter_name", `joint owner for "Together"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got %v", want, err)
+		}
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written for an invalid FBAR, got %d bytes", buf.Len())
+	}
+}
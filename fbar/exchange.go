@@ -0,0 +1,154 @@
+package fbar
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+// CurrencyAUD is the Treasury's description of the Australian dollar in the Reporting Rates of Exchange
+const CurrencyAUD = "Australia-Dollar"
+
+// bundledRates is a snapshot of the year-end AUD rows from the Treasury Reporting Rates of Exchange, as exported from
+// https://fiscaldata.treasury.gov/datasets/treasury-reporting-rates-exchange/treasury-reporting-rates-of-exchange
+// If the year you're reporting on isn't in here, download a fresh CSV from Fiscal Data and pass it in instead.
+//
+//go:embed treasury_rates.csv
+var bundledRates string
+
+// ExchangeRate is a single row of the Treasury Reporting Rates of Exchange
+// Rate is expressed in units of foreign currency per US dollar
+type ExchangeRate struct {
+	RecordDate time.Time
+	Currency   string
+	Rate       *big.Rat
+}
+
+// ExchangeRates is a table of Treasury Reporting Rates of Exchange, keyed by currency description
+type ExchangeRates struct {
+	rates map[string][]ExchangeRate
+}
+
+type exchangeRateRow struct {
+	RecordDate string `csv:"Record Date"`
+	Currency   string `csv:"Country - Currency Description"`
+	Rate       string `csv:"Exchange Rate"`
+}
+
+// DefaultExchangeRates returns the rate table bundled with this program
+func DefaultExchangeRates() (*ExchangeRates, error) {
+	return LoadExchangeRates(strings.NewReader(bundledRates))
+}
+
+// LoadExchangeRatesFile loads a rate table from a CSV exported from Fiscal Data
+func LoadExchangeRatesFile(path string) (*ExchangeRates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exchange rate file: %w", err)
+	}
+	defer f.Close()
+
+	return LoadExchangeRates(f)
+}
+
+// LoadExchangeRates parses a rate table in the Fiscal Data CSV export format
+// Columns other than the record date, currency description and exchange rate are ignored
+func LoadExchangeRates(r io.Reader) (*ExchangeRates, error) {
+	var rows []exchangeRateRow
+	if err := gocsv.Unmarshal(r, &rows); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal exchange rates: %w", err)
+	}
+
+	er := &ExchangeRates{rates: make(map[string][]ExchangeRate)}
+	for i, row := range rows {
+		recordDate, err := time.Parse(time.DateOnly, row.RecordDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse record date on row %d: %w", i+1, err)
+		}
+
+		rate, ok := new(big.Rat).SetString(row.Rate)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate %q on row %d", row.Rate, i+1)
+		}
+
+		er.rates[row.Currency] = append(er.rates[row.Currency], ExchangeRate{
+			RecordDate: recordDate,
+			Currency:   row.Currency,
+			Rate:       rate,
+		})
+	}
+
+	return er, nil
+}
+
+// YearEndRate returns the rate for the given currency to use for an FBAR covering the given calendar year. FinCEN
+// instructs filers to use the rate for the last day of the calendar year, so this is the latest rate recorded in that
+// year; anything else is an error rather than a guess
+func (e *ExchangeRates) YearEndRate(currency string, year int) (ExchangeRate, error) {
+	var best *ExchangeRate
+	for _, rate := range e.rates[currency] {
+		if rate.RecordDate.Year() != year {
+			continue
+		}
+
+		if best == nil || rate.RecordDate.After(best.RecordDate) {
+			best = &rate
+		}
+	}
+
+	if best == nil {
+		return ExchangeRate{}, fmt.Errorf("no %s exchange rate recorded for %d, supply a newer Treasury Reporting Rates of Exchange CSV", currency, year)
+	}
+
+	if best.RecordDate.Month() != time.December || best.RecordDate.Day() != 31 {
+		return ExchangeRate{}, fmt.Errorf("latest %s exchange rate for %d is from %s, not the end of the year", currency, year, best.RecordDate.Format(time.DateOnly))
+	}
+
+	return *best, nil
+}
+
+// LatestRate returns the most recent rate for the given currency. It's only good for estimates while a year is still
+// in progress; reports should use YearEndRate
+func (e *ExchangeRates) LatestRate(currency string) (ExchangeRate, error) {
+	var best *ExchangeRate
+	for _, rate := range e.rates[currency] {
+		if best == nil || rate.RecordDate.After(best.RecordDate) {
+			best = &rate
+		}
+	}
+
+	if best == nil {
+		return ExchangeRate{}, fmt.Errorf("no %s exchange rates recorded", currency)
+	}
+
+	return *best, nil
+}
+
+// ToUSD converts an amount in foreign currency base units (ie, cents) to whole US dollars, rounding up as FinCEN
+// instructs for maximum account values
+func (r ExchangeRate) ToUSD(amount int) int {
+	// amount / 100 / rate, with the rate being foreign units per dollar
+	usd := new(big.Rat).SetFrac64(int64(amount), 100)
+	usd.Quo(usd, r.Rate)
+
+	whole := new(big.Int).Quo(usd.Num(), usd.Denom())
+	if usd.Sign() > 0 && !usd.IsInt() {
+		whole.Add(whole, big.NewInt(1))
+	}
+
+	return int(whole.Int64())
+}
+
+func (r ExchangeRate) String() string {
+	return fmt.Sprintf("%s %s per USD (as of %s)", r.Rate.FloatString(3), r.Currency, r.RecordDate.Format(time.DateOnly))
+}
+
+func PrettyUSD(amount int) string {
+	return fmt.Sprintf("USD $%d", amount)
+}
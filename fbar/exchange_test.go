@@ -0,0 +1,56 @@
+package fbar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYearEndRate(t *testing.T) {
+	rates, err := LoadExchangeRates(strings.NewReader(`Record Date,Country - Currency Description,Exchange Rate,Effective Date
+2023-12-31,Australia-Dollar,1.465,2023-12-31
+2023-09-30,Australia-Dollar,1.551,2023-09-30
+2023-12-31,Canada-Dollar,1.319,2023-12-31
+2022-12-31,Australia-Dollar,1.466,2022-12-31
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rate, err := rates.YearEndRate(CurrencyAUD, 2023)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if rate.Rate.FloatString(3) != "1.465" {
+		t.Errorf("expected rate to be 1.465, got %s", rate.Rate.FloatString(3))
+	}
+
+	if _, err := rates.YearEndRate(CurrencyAUD, 2024); err == nil {
+		t.Errorf("expected an error for a year with no rates, got nil")
+	}
+}
+
+func TestToUSD(t *testing.T) {
+	rates, err := DefaultExchangeRates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rate, err := rates.YearEndRate(CurrencyAUD, 2023)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[int]int{
+		0:       0,
+		146500:  1000, // exactly $1000, shouldn't be rounded up
+		146501:  1001,
+		1000000: 6826,
+	}
+
+	for aud, want := range cases {
+		if got := rate.ToUSD(aud); got != want {
+			t.Errorf("expected %d AUD cents to be USD $%d, got $%d", aud, want, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package fbar
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/ledger"
+)
+
+// Household is the combined position across every account in the report. Money moving between the accounts cancels
+// out, so unlike the sum of per-account high water marks, the household high water mark is the most that was actually
+// held at once
+type Household struct {
+	DailyBalances     []ledger.DailyBalance
+	HighWaterMark     int
+	HighWaterMarkDate time.Time
+	HighWaterMarkUSD  int
+
+	// Transfers is every transfer between the household's accounts, including those missing one side
+	Transfers []ledger.Transfer
+}
+
+func (g *generator) household(ledgers map[string]*ledger.Ledger) Household {
+	h := Household{Transfers: ledger.MatchTransfers(ledgers, g.yearStart, g.yearEnd)}
+
+	all := make([]*ledger.Ledger, 0, len(ledgers))
+	for _, l := range ledgers {
+		all = append(all, l)
+	}
+
+	h.DailyBalances = ledger.CombinedDailyBalances(all, g.yearStart, g.yearEnd)
+	for _, db := range h.DailyBalances {
+		if db.Balance > h.HighWaterMark || h.HighWaterMarkDate.IsZero() {
+			h.HighWaterMark, h.HighWaterMarkDate = db.Balance, db.Date
+		}
+	}
+	h.HighWaterMarkUSD = g.rate.ToUSD(h.HighWaterMark)
+
+	return h
+}
+
+// reviewed returns whether whichever side of a transfer was found has been tagged TagReviewed
+func reviewed(t ledger.Transfer, ledgers map[string]*ledger.Ledger) bool {
+	for _, side := range [][2]string{{t.FromAccountID, t.DebitID}, {t.ToAccountID, t.CreditID}} {
+		l, ok := ledgers[side[0]]
+		entryID := side[1]
+		if !ok || entryID == "" {
+			continue
+		}
+
+		for _, e := range l.Entries {
+			if e.ID == entryID && slices.Contains(e.Tags, TagReviewed) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// counterpartMissing returns whether a transfer's other side is on an account with no ledger, such as one that was
+// opened after the report year or failed to load, in which case there was nowhere to look for it
+func counterpartMissing(t ledger.Transfer, ledgers map[string]*ledger.Ledger) bool {
+	other := t.ToAccountID
+	if t.DebitID == "" {
+		other = t.FromAccountID
+	}
+
+	_, ok := ledgers[other]
+	return other != "" && !ok
+}
+
+func unmatchedTransferWarning(t ledger.Transfer, ledgers map[string]*ledger.Ledger) error {
+	name := func(id string) string {
+		if l, ok := ledgers[id]; ok {
+			return l.AccountName
+		}
+		return "an unknown account"
+	}
+
+	if t.DebitID != "" {
+		return fmt.Errorf("transfer %s of %s from %s to %s has no matching credit", t.DebitID, PrettyMoney(t.Amount), name(t.FromAccountID), name(t.ToAccountID))
+	}
+
+	return fmt.Errorf("transfer %s of %s from %s to %s has no matching debit", t.CreditID, PrettyMoney(t.Amount), name(t.FromAccountID), name(t.ToAccountID))
+}
+
+func (h Household) PrettyString() string {
+	sb := strings.Builder{}
+
+	matched, roundUps := 0, 0
+	for _, t := range h.Transfers {
+		switch {
+		case !t.Matched():
+		case t.Label == ledger.LabelRoundUp:
+			roundUps++
+		default:
+			matched++
+		}
+	}
+
+	sb.WriteString("Household (all accounts combined, internal transfers netted out):\n")
+	sb.WriteString(fmt.Sprintf("\tHighest end of day balance: %s on %s\n", PrettyMoney(h.HighWaterMark), h.HighWaterMarkDate.Format(time.DateOnly)))
+	sb.WriteString(fmt.Sprintf("\tMaximum value: %s\n", PrettyUSD(h.HighWaterMarkUSD)))
+	sb.WriteString(fmt.Sprintf("\tInternal transfers: %d, round-ups: %d, unmatched: %d\n", matched, roundUps, len(h.Transfers)-matched-roundUps))
+
+	return sb.String()
+}
@@ -5,96 +5,421 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"os"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/gocarina/gocsv"
 	"github.com/moskyb/upbank-fbar-calculator/ledger"
+	"github.com/moskyb/upbank-fbar-calculator/store"
 	"github.com/moskyb/upbank-fbar-calculator/upapi"
 )
 
 type Report struct {
 	FinancialYear int
-	Entries       map[string]ReportEntry
+	// Location is the timezone the report year starts and ends in
+	Location     *time.Location
+	ExchangeRate ExchangeRate
+	Entries      map[string]ReportEntry
+	// Filtered are the entries for accounts left out by WithAccountFilter. They aren't shown, but they still count
+	// towards the FBAR and Form 8938 thresholds, which apply across every account
+	Filtered  map[string]ReportEntry
+	Threshold ThresholdResult
+	Household Household
+	// Warnings are problems that didn't stop the report from being generated, but that might make it wrong
+	Warnings []error
+
+	outputDir string
 }
 
 type AccountRecord struct {
-	DisplayName    string `json:"display_name"`
-	AccountType    string `json:"account_type"`
-	Ownership      string `json:"ownership"`
-	ClosingBalance int    `json:"closing_balance"`
-	HighWaterMark  int    `json:"high_water_mark"`
+	DisplayName      string       `json:"display_name" csv:"display_name"`
+	AccountType      string       `json:"account_type" csv:"account_type"`
+	Ownership        string       `json:"ownership" csv:"ownership"`
+	OpeningBalance   ledger.Money `json:"opening_balance" csv:"opening_balance"`
+	ClosingBalance   ledger.Money `json:"closing_balance" csv:"closing_balance"`
+	HighWaterMark    ledger.Money `json:"high_water_mark" csv:"high_water_mark"`
+	HighWaterMarkUSD int          `json:"high_water_mark_usd" csv:"high_water_mark_usd"`
+}
+
+// Anchoring is how balances are reconstructed from an account's transactions
+type Anchoring string
+
+const (
+	// AnchorForward sums transactions forwards from a zero balance when the account was opened
+	AnchorForward Anchoring = "forward"
+	// AnchorBackward walks transactions backwards from the account's current balance
+	AnchorBackward Anchoring = "backward"
+)
+
+// DefaultParallelism is how many accounts are processed at once if WithParallelism isn't given
+const DefaultParallelism = 4
+
+// TagReviewed is the Up tag for marking a transaction as checked by hand. Reviewed transactions don't raise warnings
+// about transfers with a missing side
+const TagReviewed = "fbar-reviewed"
+
+type reportConfig struct {
+	exchangeRates *ExchangeRates
+	strict        bool
+	anchoring     Anchoring
+	parallelism   int
+	failFast      bool
+	client        *upapi.Client
+	store         *store.Store
+	ledgerOpts    []ledger.Option
+	location      *time.Location
+	outputDir     string
+	keepAccount   func(upapi.Account) bool
+}
+
+type ReportOption func(*reportConfig)
+
+// WithExchangeRates sets the Treasury rate table used to convert balances to USD. Without it, the bundled table is used
+func WithExchangeRates(rates *ExchangeRates) ReportOption {
+	return func(c *reportConfig) {
+		c.exchangeRates = rates
+	}
+}
+
+// WithStrictReconciliation makes any discrepancy between a reconstructed ledger and the balance Up reports an error,
+// rather than a warning on the report
+func WithStrictReconciliation() ReportOption {
+	return func(c *reportConfig) {
+		c.strict = true
+	}
+}
+
+// WithAnchoring sets how balances are reconstructed. The default is AnchorForward
+func WithAnchoring(anchoring Anchoring) ReportOption {
+	return func(c *reportConfig) {
+		c.anchoring = anchoring
+	}
+}
+
+// WithParallelism sets how many accounts are fetched and processed at once
+func WithParallelism(n int) ReportOption {
+	return func(c *reportConfig) {
+		c.parallelism = n
+	}
+}
+
+// WithFailFast makes the first error processing an account cancel all outstanding work, rather than carrying on and
+// reporting every error at the end
+func WithFailFast() ReportOption {
+	return func(c *reportConfig) {
+		c.failFast = true
+	}
+}
+
+// WithClient sets the client used to talk to Up, in which case the token passed to GenerateReport is ignored
+func WithClient(client *upapi.Client) ReportOption {
+	return func(c *reportConfig) {
+		c.client = client
+	}
+}
+
+// WithStore keeps transactions in a local store, so that only new transactions need to be fetched from Up
+func WithStore(s *store.Store) ReportOption {
+	return func(c *reportConfig) {
+		c.store = s
+	}
+}
+
+// WithLedgerOptions sets how each account's ledger is built, such as whether held transactions are counted
+func WithLedgerOptions(opts ...ledger.Option) ReportOption {
+	return func(c *reportConfig) {
+		c.ledgerOpts = append(c.ledgerOpts, opts...)
+	}
 }
 
-func GenerateReport(upAPIToken string, year int) (*Report, error) {
-	zone, err := time.LoadLocation("Australia/Sydney")
+// WithLocation sets the timezone that the report year starts and ends in. The default is Australia/Sydney, which is
+// where Up operates, but filers may prefer the timezone they live in
+func WithLocation(loc *time.Location) ReportOption {
+	return func(c *reportConfig) {
+		c.location = loc
+	}
+}
+
+// WithOutputDir sets the directory CSVs are written to. The default is the working directory
+func WithOutputDir(dir string) ReportOption {
+	return func(c *reportConfig) {
+		c.outputDir = dir
+	}
+}
+
+// WithAccountFilter limits the report's entries, warnings and CSVs to accounts for which keep returns true. Every
+// account is still fetched, so that transfers to accounts left out can be matched, and the household figures and
+// filing thresholds stay complete
+func WithAccountFilter(keep func(upapi.Account) bool) ReportOption {
+	return func(c *reportConfig) {
+		c.keepAccount = keep
+	}
+}
+
+// GenerateReport builds a report covering every Up account held during the given calendar year. Accounts are
+// processed concurrently, but never more than the configured parallelism at once. Errors for individual accounts are
+// collected and returned alongside the partial report unless WithFailFast is set, in which case the first one cancels
+// everything still outstanding
+func GenerateReport(ctx context.Context, upAPIToken string, year int, opts ...ReportOption) (*Report, error) {
+	cfg := &reportConfig{anchoring: AnchorForward, parallelism: DefaultParallelism}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if cfg.anchoring != AnchorForward && cfg.anchoring != AnchorBackward {
+		return nil, fmt.Errorf("unknown anchoring %q", cfg.anchoring)
+	}
+
+	if cfg.parallelism < 1 {
+		return nil, fmt.Errorf("parallelism must be at least 1, got %d", cfg.parallelism)
+	}
+
+	if cfg.exchangeRates == nil {
+		rates, err := DefaultExchangeRates()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load bundled exchange rates: %w", err)
+		}
+		cfg.exchangeRates = rates
+	}
+
+	rate, err := cfg.exchangeRates.YearEndRate(CurrencyAUD, year)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load timezone: %w", err)
+		return nil, fmt.Errorf("failed to find exchange rate: %w", err)
+	}
+
+	zone := cfg.location
+	if zone == nil {
+		zone, err = time.LoadLocation("Australia/Sydney")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load timezone: %w", err)
+		}
+	}
+
+	// The ledger's year boundaries must always match the report's, but let any other ledger options through
+	cfg.ledgerOpts = append(cfg.ledgerOpts, ledger.WithLocation(zone))
+
+	client := cfg.client
+	if client == nil {
+		client = upapi.NewClient(upAPIToken, upapi.WithQuiet())
+	}
+
+	g := &generator{
+		cfg:       cfg,
+		client:    client,
+		year:      year,
+		yearStart: time.Date(year, time.January, 1, 0, 0, 0, 0, zone),
+		yearEnd:   time.Date(year+1, time.January, 1, 0, 0, 0, 0, zone),
+		rate:      rate,
 	}
 
-	client := upapi.NewClient(upAPIToken, upapi.WithQuiet())
-	accounts, err := client.PaginateAllAccounts(context.Background(), upapi.ListAccountsParams{})
+	accounts, err := g.client.PaginateAllAccounts(ctx, upapi.ListAccountsParams{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list accounts: %w", err)
 	}
 
-	errsMtx := sync.Mutex{}
-	errs := make([]error, 0, len(accounts))
+	kept := make(map[string]bool, len(accounts))
+	for _, acc := range accounts {
+		kept[acc.ID] = cfg.keepAccount == nil || cfg.keepAccount(acc)
+	}
 
-	r := &Report{FinancialYear: year}
-	r.Entries = make(map[string]ReportEntry, len(accounts))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan upapi.Account)
+	go func() {
+		defer close(jobs)
+		for _, acc := range accounts {
+			select {
+			case jobs <- acc:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
+	results := make(chan accountResult)
 	wg := sync.WaitGroup{}
-	for _, acc := range accounts {
+	for range min(cfg.parallelism, len(accounts)) {
 		wg.Add(1)
-		go func(acc upapi.Account) {
+		go func() {
 			defer wg.Done()
-
-			if acc.Attributes.CreatedAt.After(time.Date(year+1, time.January, 1, 0, 0, 0, 0, zone)) {
-				// Account created after the end of the calendar year we're looking at, so it doesn't need to be reported on
-				return
+			for acc := range jobs {
+				results <- g.processAccount(ctx, acc)
 			}
+		}()
+	}
 
-			xacts, err := client.PaginateAllTransactionsForAccount(context.Background(), acc.ID, upapi.ListTransactionsParams{
-				Until: time.Date(year+1, time.January, 1, 0, 0, 0, 0, zone),
-			})
-			if err != nil {
-				errsMtx.Lock()
-				errs = append(errs, fmt.Errorf("failed to list transactions for account %s: %w", acc.ID, err))
-				errsMtx.Unlock()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
-				return
-			}
+	r := &Report{FinancialYear: year, Location: zone, ExchangeRate: rate, outputDir: cfg.outputDir}
+	r.Entries = make(map[string]ReportEntry, len(accounts))
+	r.Filtered = make(map[string]ReportEntry)
 
-			ledger := ledger.FromTransactions(acc.Attributes.DisplayName, xacts)
-			r.Entries[acc.Attributes.DisplayName] = ReportEntry{
-				AccountName:      acc.Attributes.DisplayName,
-				HighWaterMark:    ledger.HighWaterMark(year),
-				ClosingBalance:   ledger.CurrentBalance,
-				TransactionCount: len(ledger.TransactionsForYear(year)),
+	var errs []error
+	failed := false
+	ledgers := make(map[string]*ledger.Ledger, len(accounts))
+	for res := range results {
+		if res.entry == nil || kept[res.entry.AccountID] {
+			r.Warnings = append(r.Warnings, res.warnings...)
+		}
+
+		if res.err != nil {
+			// Once fail fast has cancelled everything, the accounts still in flight fail too, but only the error that
+			// caused it is worth reporting
+			if failed && errors.Is(res.err, context.Canceled) {
+				continue
 			}
 
-			err = ledger.DumpCSV(year)
-			if err != nil {
-				errsMtx.Lock()
-				errs = append(errs, fmt.Errorf("failed to dump CSV for account %s: %w", acc.ID, err))
-				errsMtx.Unlock()
+			errs = append(errs, res.err)
+			if cfg.failFast {
+				failed = true
+				cancel()
+			}
+			continue
+		}
 
-				return
+		if res.entry != nil {
+			ledgers[res.entry.AccountID] = res.ledger
+			if kept[res.entry.AccountID] {
+				r.Entries[res.entry.AccountName] = *res.entry
+			} else {
+				r.Filtered[res.entry.AccountName] = *res.entry
 			}
+		}
+	}
 
-		}(acc)
+	// Transfers can only be matched once every account's ledger is in, and labelling them has to happen before the
+	// ledgers are written out
+	r.Household = g.household(ledgers)
+	for _, t := range r.Household.Transfers {
+		if (kept[t.FromAccountID] || kept[t.ToAccountID]) && !t.Matched() && !reviewed(t, ledgers) && !counterpartMissing(t, ledgers) {
+			r.Warnings = append(r.Warnings, unmatchedTransferWarning(t, ledgers))
+		}
 	}
-	wg.Wait()
+
+	for _, id := range slices.Sorted(maps.Keys(ledgers)) {
+		if !kept[id] {
+			continue
+		}
+
+		if err := ledgers[id].DumpCSV(cfg.outputDir, year); err != nil {
+			errs = append(errs, fmt.Errorf("failed to dump CSV for account %s: %w", id, err))
+		}
+	}
+
+	// Results arrive in whatever order the workers finish in, so sort for consistent output
+	slices.SortFunc(r.Warnings, func(i, j error) int { return strings.Compare(i.Error(), j.Error()) })
+	slices.SortFunc(errs, func(i, j error) int { return strings.Compare(i.Error(), j.Error()) })
+
+	r.Threshold = r.EvaluateThreshold()
 
 	return r, errors.Join(errs...)
 }
 
+type generator struct {
+	cfg    *reportConfig
+	client *upapi.Client
+
+	year               int
+	yearStart, yearEnd time.Time
+	rate               ExchangeRate
+}
+
+// buildLedger fetches the full history of an account rather than stopping at the end of the year, so that the ledger
+// can be reconciled against the account's current balance
+func (g *generator) buildLedger(ctx context.Context, acc upapi.Account) (*ledger.Ledger, error) {
+	xacts := g.client.TransactionsForAccount(ctx, acc.ID, upapi.ListTransactionsParams{})
+	if g.cfg.store != nil {
+		synced, err := g.cfg.store.Sync(ctx, g.client, acc.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sync transactions for account %s: %w", acc.ID, err)
+		}
+		xacts = ledger.Seq(synced)
+	}
+
+	var l *ledger.Ledger
+	var err error
+	if g.cfg.anchoring == AnchorBackward {
+		l, err = ledger.FromTransactionSeqAnchored(acc.Attributes.DisplayName, acc.Attributes.Balance.ValueInBaseUnits, xacts, g.cfg.ledgerOpts...)
+	} else {
+		l, err = ledger.FromTransactionSeq(acc.Attributes.DisplayName, xacts, g.cfg.ledgerOpts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions for account %s: %w", acc.ID, err)
+	}
+
+	return l, nil
+}
+
+type accountResult struct {
+	// entry and ledger are nil if the account doesn't need to be reported on
+	entry    *ReportEntry
+	ledger   *ledger.Ledger
+	warnings []error
+	err      error
+}
+
+func (g *generator) processAccount(ctx context.Context, acc upapi.Account) accountResult {
+	if acc.Attributes.CreatedAt.After(g.yearEnd) {
+		// Account created after the end of the calendar year we're looking at, so it doesn't need to be reported on
+		return accountResult{}
+	}
+
+	l, err := g.buildLedger(ctx, acc)
+	if err != nil {
+		return accountResult{err: err}
+	}
+
+	var res accountResult
+
+	discrepancy := 0
+	if err := l.Reconcile(acc.Attributes.Balance.ValueInBaseUnits); err != nil {
+		var rErr *ledger.ReconciliationError
+		if errors.As(err, &rErr) {
+			discrepancy = rErr.Offset()
+		}
+
+		if g.cfg.strict {
+			return accountResult{err: err}
+		}
+		res.warnings = append(res.warnings, err)
+	}
+
+	hwm := l.HighWaterMarkBetween(g.yearStart, g.yearEnd)
+	res.entry = &ReportEntry{
+		AccountID:        acc.ID,
+		AccountName:      acc.Attributes.DisplayName,
+		AccountType:      acc.Attributes.AccountType,
+		Ownership:        acc.Attributes.OwnershipType,
+		OpenedAt:         acc.Attributes.CreatedAt,
+		HighWaterMark:    hwm,
+		HighWaterMarkUSD: g.rate.ToUSD(hwm),
+		OpeningBalance:   l.BalanceAt(g.yearStart),
+		ClosingBalance:   l.BalanceAt(g.yearEnd),
+		TransactionCount: len(l.TransactionsForYear(g.year)),
+		Dormant:          l.Dormant(g.year),
+		Discrepancy:      discrepancy,
+		HeldCount:        len(l.Held),
+	}
+
+	res.ledger = l
+
+	return res
+}
+
 func (r *Report) PrettyString() string {
 	sb := strings.Builder{}
 	sb.WriteString(fmt.Sprintf("FBAR Report for Upbank, CY%d\n\n", r.FinancialYear))
+	sb.WriteString(fmt.Sprintf("Year boundaries in: %s\n", r.Location))
+	sb.WriteString(fmt.Sprintf("Exchange rate: %s\n\n", r.ExchangeRate))
 	sb.WriteString(fmt.Sprintf("%d accounts held in %d:\n", len(r.Entries), r.FinancialYear))
 
 	sortedEntries := slices.SortedFunc(maps.Values(r.Entries), func(i, j ReportEntry) int {
@@ -102,14 +427,43 @@ func (r *Report) PrettyString() string {
 	})
 
 	for _, entry := range sortedEntries {
+		if entry.Dormant {
+			sb.WriteString(fmt.Sprintf("\t%s (dormant)\n", entry.AccountName))
+			continue
+		}
 		sb.WriteString(fmt.Sprintf("\t%s\n", entry.AccountName))
 	}
 	sb.WriteString("\n")
 
+	sb.WriteString(r.Threshold.PrettyString())
+	sb.WriteString("\n")
+
+	sb.WriteString(r.Household.PrettyString())
+	sb.WriteString("\n")
+
+	if len(r.Warnings) > 0 {
+		sb.WriteString("WARNINGS:\n")
+		for _, w := range r.Warnings {
+			sb.WriteString(fmt.Sprintf("\t%s\n", w))
+		}
+		sb.WriteString("\n")
+	}
+
 	for _, entry := range sortedEntries {
 		sb.WriteString(fmt.Sprintf("Account: %s\n", entry.AccountName))
 		sb.WriteString(fmt.Sprintf("\tTransaction count: %d\n", entry.TransactionCount))
+		if entry.Discrepancy != 0 {
+			sb.WriteString(fmt.Sprintf("\tWARNING: reconstructed balance is off by %s, these figures may be wrong\n", PrettyMoney(entry.Discrepancy)))
+		}
+		if entry.HeldCount != 0 {
+			sb.WriteString(fmt.Sprintf("\tWARNING: %d transactions are still held, and may change when they settle\n", entry.HeldCount))
+		}
+		if entry.Dormant {
+			sb.WriteString(fmt.Sprintf("\tNo transactions in %d, balance carried forward from the previous year\n", r.FinancialYear))
+		}
+		sb.WriteString(fmt.Sprintf("\tOpening balance: %s\n", PrettyMoney(entry.OpeningBalance)))
 		sb.WriteString(fmt.Sprintf("\tHigh water mark: %s\n", PrettyMoney(entry.HighWaterMark)))
+		sb.WriteString(fmt.Sprintf("\tMaximum value: %s\n", PrettyUSD(entry.HighWaterMarkUSD)))
 		sb.WriteString(fmt.Sprintf("\tClosing balance: %s\n", PrettyMoney(entry.ClosingBalance)))
 		sb.WriteString("\n")
 	}
@@ -117,6 +471,51 @@ func (r *Report) PrettyString() string {
 	return sb.String()
 }
 
+// AllEntries returns the entries for every account, including those left out by WithAccountFilter, sorted by account
+// name
+func (r *Report) AllEntries() []ReportEntry {
+	entries := slices.AppendSeq(slices.Collect(maps.Values(r.Entries)), maps.Values(r.Filtered))
+	slices.SortFunc(entries, func(i, j ReportEntry) int { return strings.Compare(i.AccountName, j.AccountName) })
+
+	return entries
+}
+
+// Records returns a record for each entry in the report, sorted by account name
+func (r *Report) Records() []AccountRecord {
+	records := make([]AccountRecord, 0, len(r.Entries))
+	for _, name := range slices.Sorted(maps.Keys(r.Entries)) {
+		entry := r.Entries[name]
+		records = append(records, AccountRecord{
+			DisplayName:      entry.AccountName,
+			AccountType:      entry.AccountType,
+			Ownership:        entry.Ownership,
+			OpeningBalance:   ledger.Money(entry.OpeningBalance),
+			ClosingBalance:   ledger.Money(entry.ClosingBalance),
+			HighWaterMark:    ledger.Money(entry.HighWaterMark),
+			HighWaterMarkUSD: entry.HighWaterMarkUSD,
+		})
+	}
+
+	return records
+}
+
+// DumpCSV writes the summary of each account to fbar-<year>.csv, in the directory set by WithOutputDir
+func (r *Report) DumpCSV() error {
+	name := filepath.Join(r.outputDir, fmt.Sprintf("fbar-%d.csv", r.FinancialYear))
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	err = gocsv.MarshalFile(r.Records(), f)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CSV: %w", err)
+	}
+
+	return nil
+}
+
 var emojiRE = regexp.MustCompile(`[[:^ascii:]]`)
 
 func stripEmoji(s string) string {
@@ -124,13 +523,30 @@ func stripEmoji(s string) string {
 }
 
 func PrettyMoney(amount int) string {
-	return fmt.Sprintf("AUD $%d.%02d", amount/100.0, amount%100.0)
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	return fmt.Sprintf("%sAUD $%d.%02d", sign, amount/100, amount%100)
 }
 
 type ReportEntry struct {
+	AccountID        string
 	AccountName      string
+	AccountType      string
+	Ownership        string
+	OpenedAt         time.Time
 	TransactionCount int
+	// Dormant is set when the account had history before the report year but no transactions during it
+	Dormant bool
+	// Discrepancy is how far the reconstructed current balance is from the one Up reports. Anything other than zero
+	// means the other balances in the entry can't be trusted
+	Discrepancy int
+	// HeldCount is the number of transactions on the account that hadn't settled when the report was generated
+	HeldCount        int
 	HighWaterMark    int
+	HighWaterMarkUSD int
 	OpeningBalance   int
 	ClosingBalance   int
 }
@@ -0,0 +1,212 @@
+package fbar
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+	"github.com/moskyb/upbank-fbar-calculator/upapitest"
+)
+
+func TestGenerateReport(t *testing.T) {
+	t.Chdir(t.TempDir()) // GenerateReport dumps CSVs into the working directory
+
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, sydney)
+	}
+
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	defer srv.Close()
+
+	srv.AddAccount(upapitest.Account("spending", "Spending", 1_000_00, at(2022, time.January, 1)),
+		upapitest.Transaction("1", "Salary", 2_000_00, at(2022, time.June, 1)),
+		upapitest.Transaction("2", "Salary", 15_000_00, at(2023, time.March, 1)),
+		upapitest.Transaction("3", "Rent", -16_000_00, at(2023, time.April, 1)),
+	)
+	srv.AddAccount(upapitest.Account("saver", "Saver", 5_000_00, at(2021, time.January, 1)),
+		upapitest.Transaction("4", "Transfer", 5_000_00, at(2021, time.June, 1)),
+	)
+	srv.AddAccount(upapitest.Account("new", "New", 0, at(2024, time.June, 1)))
+
+	// Small pages and a rate limit make sure pagination and retries are exercised too
+	srv.SetRateLimit(5)
+	client := srv.Client(upapi.WithPageSize(1), upapi.WithRetryPolicy(upapi.RetryPolicy{MaxAttempts: 3}))
+
+	r, err := GenerateReport(context.Background(), "", 2023, WithClient(client), WithParallelism(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(r.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %+v", len(r.Entries), r.Entries)
+	}
+
+	spending := r.Entries["Spending"]
+	if spending.OpeningBalance != 2_000_00 || spending.HighWaterMark != 17_000_00 || spending.ClosingBalance != 1_000_00 {
+		t.Errorf("unexpected balances for Spending: %+v", spending)
+	}
+
+	saver := r.Entries["Saver"]
+	if !saver.Dormant || saver.HighWaterMark != 5_000_00 {
+		t.Errorf("expected Saver to be dormant with a high water mark of 5000.00, got %+v", saver)
+	}
+
+	if len(r.Warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", r.Warnings)
+	}
+
+	if !strings.Contains(r.PrettyString(), "Saver (dormant)") {
+		t.Errorf("expected pretty output to call out the dormant Saver, got:\n%s", r.PrettyString())
+	}
+}
+
+func TestGenerateReportUnauthorized(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	defer srv.Close()
+
+	srv.AddAccount(upapitest.Account("spending", "Spending", 0, time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)))
+
+	_, err := GenerateReport(context.Background(), "", 2023, WithClient(upapi.NewClient("wrong", upapi.WithQuiet(), upapi.WithHost(srv.URL))))
+	if err == nil {
+		t.Fatalf("expected an error with the wrong token, got nil")
+	}
+}
+
+func TestPrettyMoney(t *testing.T) {
+	for amount, expected := range map[int]string{1234_56: "AUD $1234.56", 5: "AUD $0.05", -5_50: "-AUD $5.50", -5: "-AUD $0.05"} {
+		if got := PrettyMoney(amount); got != expected {
+			t.Errorf("expected %d cents to be %q, got %q", amount, expected, got)
+		}
+	}
+}
+
+func TestGenerateReportReviewedTransfer(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	defer srv.Close()
+
+	srv.AddAccount(upapitest.Account("spending", "Spending", -100_00, at.AddDate(-1, 0, 0)),
+		upapitest.Transaction("1", "Transfer to Partner", -100_00, at),
+	)
+	client := srv.Client()
+
+	r, err := GenerateReport(context.Background(), "", 2023, WithClient(client))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(r.Warnings) != 1 {
+		t.Fatalf("expected a warning about the one-sided transfer, got %v", r.Warnings)
+	}
+
+	if err := client.AddTagsToTransaction(context.Background(), "1", TagReviewed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tags, err := client.PaginateAllTags(context.Background(), upapi.ListTagsParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tags) != 1 || tags[0].ID != TagReviewed {
+		t.Errorf("expected only the %s tag, got %+v", TagReviewed, tags)
+	}
+
+	r, err = GenerateReport(context.Background(), "", 2023, WithClient(client))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(r.Warnings) != 0 {
+		t.Errorf("expected no warnings once the transfer was reviewed, got %v", r.Warnings)
+	}
+}
+
+func TestGenerateReportTransfersOutsideYear(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	defer srv.Close()
+
+	toNextYear := upapitest.Transaction("2", "Transfer to Holiday", -50_00, at)
+	toNextYear.Relationships.TransferAccount.Data = &upapi.ResourceIdentifier{Type: upapi.ResourceTypeAccounts, ID: "holiday"}
+
+	srv.AddAccount(upapitest.Account("spending", "Spending", 850_00, at.AddDate(-3, 0, 0)),
+		upapitest.Transaction("1", "Salary", 1_000_00, at.AddDate(-3, 0, 0)),
+		upapitest.Transaction("old", "Transfer to Partner", -100_00, at.AddDate(-2, 0, 0)),
+		toNextYear,
+	)
+	srv.AddAccount(upapitest.Account("holiday", "Holiday", 50_00, at.AddDate(1, 0, 0)))
+
+	r, err := GenerateReport(context.Background(), "", 2023, WithClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(r.Warnings) != 0 {
+		t.Errorf("expected no warnings about transfers outside the year or to accounts not reported on, got %v", r.Warnings)
+	}
+}
+
+func TestGenerateReportAccountFilter(t *testing.T) {
+	dir := t.TempDir()
+
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	defer srv.Close()
+
+	srv.AddAccount(upapitest.Account("spending", "Spending", 900_00, at.AddDate(-1, 0, 0)),
+		upapitest.Transaction("1", "Salary", 1_000_00, at.AddDate(-1, 0, 0)),
+		upapitest.Transaction("2", "Transfer to Saver", -100_00, at),
+	)
+	// The saver doesn't reconcile, but since it's filtered out, that shouldn't be warned about
+	srv.AddAccount(upapitest.Account("saver", "Saver", 20_150_00, at.AddDate(-1, 0, 0)),
+		upapitest.Transaction("4", "Deposit", 20_000_00, at.AddDate(-1, 0, 0)),
+		upapitest.Transaction("3", "Transfer from Spending", 100_00, at),
+	)
+
+	r, err := GenerateReport(context.Background(), "", 2023, WithClient(srv.Client()), WithOutputDir(dir),
+		WithAccountFilter(func(acc upapi.Account) bool { return acc.ID == "spending" }))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := r.Entries["Spending"]; !ok || len(r.Entries) != 1 {
+		t.Errorf("expected only the Spending account to be reported on, got %v", slices.Collect(maps.Keys(r.Entries)))
+	}
+
+	if len(r.Warnings) != 0 {
+		t.Errorf("expected no warnings about the filtered out account, got %v", r.Warnings)
+	}
+
+	if !r.Threshold.FilingRequired || len(r.Threshold.Contributing) != 2 {
+		t.Errorf("expected the threshold to count the filtered out account, got %+v", r.Threshold)
+	}
+
+	if r.Household.HighWaterMark != 21_000_00 {
+		t.Errorf("expected the household to include every account, got %s", PrettyMoney(r.Household.HighWaterMark))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "Saver.csv")); !os.IsNotExist(err) {
+		t.Errorf("expected no CSV for the filtered out account, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package fbar
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FilingThresholdUSD is the aggregate maximum value of foreign accounts above which an FBAR must be filed
+const FilingThresholdUSD = 10_000
+
+type ThresholdResult struct {
+	AggregateUSD   int  `json:"aggregate_usd"`
+	ThresholdUSD   int  `json:"threshold_usd"`
+	FilingRequired bool `json:"filing_required"`
+
+	// Contributing is the accounts that had a nonzero maximum value, largest first
+	Contributing []ThresholdContribution `json:"contributing"`
+}
+
+type ThresholdContribution struct {
+	AccountName     string `json:"account_name"`
+	MaximumValueUSD int    `json:"maximum_value_usd"`
+}
+
+// EvaluateThreshold sums the USD maximum values of every account in the report, including those left out by
+// WithAccountFilter, and checks them against the FBAR filing threshold. Note that the threshold applies across all of
+// the filer's foreign accounts, not just those with Up, so a "not required" verdict here only means that Up accounts
+// alone don't push you over it
+func (r *Report) EvaluateThreshold() ThresholdResult {
+	res := ThresholdResult{ThresholdUSD: FilingThresholdUSD}
+
+	for _, entry := range r.AllEntries() {
+		if entry.HighWaterMarkUSD <= 0 {
+			continue
+		}
+
+		res.AggregateUSD += entry.HighWaterMarkUSD
+		res.Contributing = append(res.Contributing, ThresholdContribution{
+			AccountName:     entry.AccountName,
+			MaximumValueUSD: entry.HighWaterMarkUSD,
+		})
+	}
+
+	slices.SortFunc(res.Contributing, func(i, j ThresholdContribution) int {
+		if c := cmp.Compare(j.MaximumValueUSD, i.MaximumValueUSD); c != 0 {
+			return c
+		}
+		return strings.Compare(stripEmoji(i.AccountName), stripEmoji(j.AccountName))
+	})
+
+	// The requirement is to file if the aggregate is *more than* $10,000
+	res.FilingRequired = res.AggregateUSD > res.ThresholdUSD
+
+	return res
+}
+
+func (t ThresholdResult) PrettyString() string {
+	sb := strings.Builder{}
+
+	verdict := "FBAR filing NOT required based on Up accounts alone"
+	if t.FilingRequired {
+		verdict = "FBAR filing REQUIRED"
+	}
+
+	sb.WriteString(fmt.Sprintf("%s: aggregate maximum value %s vs threshold %s\n", verdict, PrettyUSD(t.AggregateUSD), PrettyUSD(t.ThresholdUSD)))
+	for _, c := range t.Contributing {
+		sb.WriteString(fmt.Sprintf("\t%s: %s\n", c.AccountName, PrettyUSD(c.MaximumValueUSD)))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,31 @@
+package fbar
+
+import "testing"
+
+func TestEvaluateThreshold(t *testing.T) {
+	r := &Report{
+		Entries: map[string]ReportEntry{
+			"Spending": {AccountName: "Spending", HighWaterMarkUSD: 4000},
+			"Saver":    {AccountName: "Saver", HighWaterMarkUSD: 6000},
+			"Empty":    {AccountName: "Empty"},
+		},
+	}
+
+	res := r.EvaluateThreshold()
+	if res.AggregateUSD != 10000 {
+		t.Errorf("expected aggregate to be 10000, got %d", res.AggregateUSD)
+	}
+
+	if res.FilingRequired {
+		t.Errorf("expected filing not to be required at exactly the threshold, but it was")
+	}
+
+	if len(res.Contributing) != 2 || res.Contributing[0].AccountName != "Saver" {
+		t.Errorf("expected Saver and Spending to contribute, largest first, got %+v", res.Contributing)
+	}
+
+	r.Entries["Empty"] = ReportEntry{AccountName: "Empty", HighWaterMarkUSD: 1}
+	if !r.EvaluateThreshold().FilingRequired {
+		t.Errorf("expected filing to be required over the threshold, but it wasn't")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lmittmann/tint"
+	"github.com/mattn/go-isatty"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// Output formats accepted by -format
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// usageError is a problem with how the program was invoked, rather than something going wrong while it ran
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// envDefaults reads flag defaults from the environment variables that configured the program before it had flags.
+// A value that doesn't parse is remembered rather than ending the program there and then, so that -help still works,
+// and is reported by err once the flags have been parsed
+type envDefaults struct {
+	errs []error
+}
+
+// string returns the environment variable's value, or fallback if it's unset
+func (e *envDefaults) string(name, fallback string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+// bool returns the environment variable as a bool, or false if it's unset
+func (e *envDefaults) bool(name string) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.errs = append(e.errs, usageErrorf("invalid value %q for %s: expected true or false", v, name))
+	}
+
+	return b
+}
+
+// int returns the environment variable as an int, or fallback if it's unset
+func (e *envDefaults) int(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.errs = append(e.errs, usageErrorf("invalid value %q for %s: expected a whole number", v, name))
+		return fallback
+	}
+
+	return n
+}
+
+// err returns a usage error for every environment variable that couldn't be parsed, or nil if they all were
+func (e *envDefaults) err() error {
+	return errors.Join(e.errs...)
+}
+
+// newFlagSet returns a flag set for a subcommand, with usage text built from its summary and flags
+func newFlagSet(name, args, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n\nFlags:\n", programName(), name, args, summary)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// commonFlags are accepted by every subcommand that talks to Up
+type commonFlags struct {
+	verbose bool
+	quiet   bool
+
+	// host overrides Up's API host, so that tests can point commands at a fake server
+	host string
+}
+
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&c.verbose, "v", false, "log every request made to Up")
+	fs.BoolVar(&c.quiet, "q", false, "only log errors")
+}
+
+func (c *commonFlags) logger() *slog.Logger {
+	level := slog.LevelWarn
+	switch {
+	case c.verbose:
+		level = slog.LevelDebug
+	case c.quiet:
+		level = slog.LevelError
+	}
+
+	w := os.Stderr
+	return slog.New(tint.NewHandler(w, &tint.Options{
+		Level:   level,
+		NoColor: !isatty.IsTerminal(w.Fd()),
+	}))
+}
+
+// token returns UP_TOKEN, which is deliberately not a flag so that it doesn't end up in shell history or process lists
+func token() (string, error) {
+	tok := os.Getenv("UP_TOKEN")
+	if tok == "" {
+		return "", usageErrorf("UP_TOKEN environment variable not set; get a token from https://api.up.com.au/getting_started")
+	}
+
+	return tok, nil
+}
+
+// client returns a client for Up using UP_TOKEN, logging at the chosen verbosity
+func (c *commonFlags) client(opts ...func(*upapi.Client)) (*upapi.Client, error) {
+	tok, err := token()
+	if err != nil {
+		return nil, err
+	}
+
+	client := upapi.NewClient(tok, upapi.WithLogger(c.logger()))
+	if c.host != "" {
+		client.Host = c.host
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	return client, nil
+}
+
+// accountFlags pick which accounts a subcommand covers
+type accountFlags struct {
+	accounts    stringList
+	accountType string
+	ownership   string
+}
+
+func (a *accountFlags) register(fs *flag.FlagSet) {
+	fs.Var(&a.accounts, "account", "only include this account, by name or ID (repeatable)")
+	fs.StringVar(&a.accountType, "account-type", "", "only include accounts of this type: saver, transactional or home_loan")
+	fs.StringVar(&a.ownership, "ownership", "", "only include accounts with this ownership: individual or joint")
+}
+
+func (a *accountFlags) validate() error {
+	if t := strings.ToUpper(a.accountType); t != "" && !slices.Contains([]string{upapi.AccountTypeSaver, upapi.AccountTypeTransactional, upapi.AccountTypeHomeLoan}, t) {
+		return usageErrorf("unknown -account-type %q", a.accountType)
+	}
+
+	if o := strings.ToUpper(a.ownership); o != "" && !slices.Contains([]string{upapi.OwnershipTypeIndividual, upapi.OwnershipTypeJoint}, o) {
+		return usageErrorf("unknown -ownership %q", a.ownership)
+	}
+
+	return nil
+}
+
+// keep returns whether an account matches the filters
+func (a *accountFlags) keep(acc upapi.Account) bool {
+	if a.accountType != "" && !strings.EqualFold(acc.Attributes.AccountType, a.accountType) {
+		return false
+	}
+
+	if a.ownership != "" && !strings.EqualFold(acc.Attributes.OwnershipType, a.ownership) {
+		return false
+	}
+
+	if len(a.accounts) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(a.accounts, func(want string) bool {
+		return want == acc.ID || strings.EqualFold(want, acc.Attributes.DisplayName) || strings.EqualFold(want, stripEmoji(acc.Attributes.DisplayName))
+	})
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// checkFormat makes sure -format is one of the allowed formats
+func checkFormat(format string, allowed ...string) error {
+	if !slices.Contains(allowed, format) {
+		return usageErrorf("unknown -format %q, expected one of %s", format, strings.Join(allowed, ", "))
+	}
+
+	return nil
+}
+
+// loadLocation loads the timezone named by -timezone, or returns nil if it's empty so the default applies
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, usageErrorf("unknown timezone %q", name)
+	}
+
+	return loc, nil
+}
+
+// stripEmoji drops the emoji Up lets people put in account names, so that they can be typed on the command line
+func stripEmoji(s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r > 0x7f {
+			return -1
+		}
+		return r
+	}, s))
+}
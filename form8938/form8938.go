@@ -0,0 +1,229 @@
+package form8938
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+type FilingStatus string
+
+const (
+	FilingStatusSingle                  FilingStatus = "single"
+	FilingStatusHeadOfHousehold         FilingStatus = "head_of_household"
+	FilingStatusMarriedFilingJointly    FilingStatus = "married_filing_jointly"
+	FilingStatusMarriedFilingSeparately FilingStatus = "married_filing_separately"
+)
+
+// ExchangeRateSource is what goes on Part V line 6(c), since we always use the same rates as the FBAR
+const ExchangeRateSource = "U.S. Treasury Reporting Rates of Exchange"
+
+type Options struct {
+	FilingStatus FilingStatus
+	// LivingAbroad is whether the taxpayer meets the presence abroad test, which raises the thresholds
+	LivingAbroad bool
+	// JointAccountsWithSpouse is whether Up joint accounts are held with a spouse. When married filing separately,
+	// only half the value of these counts towards the threshold
+	JointAccountsWithSpouse bool
+	// AccountNumbers maps account display names to account numbers for Part V line 2, the same as
+	// fbar.FilerProfile.AccountNumbers
+	AccountNumbers map[string]string
+}
+
+// Thresholds are the values of specified foreign financial assets above which Form 8938 must be filed
+type Thresholds struct {
+	YearEndUSD int
+	AnyTimeUSD int
+}
+
+// ThresholdsFor returns the reporting thresholds for a taxpayer, as set out in the Form 8938 instructions
+func ThresholdsFor(status FilingStatus, livingAbroad bool) (Thresholds, error) {
+	switch status {
+	case FilingStatusSingle, FilingStatusHeadOfHousehold, FilingStatusMarriedFilingSeparately:
+		if livingAbroad {
+			return Thresholds{YearEndUSD: 200_000, AnyTimeUSD: 300_000}, nil
+		}
+		return Thresholds{YearEndUSD: 50_000, AnyTimeUSD: 75_000}, nil
+
+	case FilingStatusMarriedFilingJointly:
+		if livingAbroad {
+			return Thresholds{YearEndUSD: 400_000, AnyTimeUSD: 600_000}, nil
+		}
+		return Thresholds{YearEndUSD: 100_000, AnyTimeUSD: 150_000}, nil
+
+	default:
+		return Thresholds{}, fmt.Errorf("unknown filing status %q", status)
+	}
+}
+
+type Summary struct {
+	TaxYear    int
+	Options    Options
+	Thresholds Thresholds
+
+	// YearEndTotalUSD and MaximumTotalUSD are the values counted towards the thresholds
+	YearEndTotalUSD int
+	MaximumTotalUSD int
+	Required        bool
+
+	PartI    PartI
+	Accounts []Account
+}
+
+// PartI is the deposit accounts section of Part I, Foreign Deposit and Custodial Accounts Summary
+type PartI struct {
+	DepositAccountCount int
+	MaximumValueUSD     int
+}
+
+// Account is the Part V, Detailed Information for Each Foreign Deposit and Custodial Account, entry for an Up account
+type Account struct {
+	AccountName    string
+	DepositAccount bool
+	// AccountNumber is empty if it wasn't given in Options.AccountNumbers
+	AccountNumber    string
+	OpenedDuringYear bool
+	// ClosedDuringYear is always false, since Up only lists open accounts
+	ClosedDuringYear bool
+	JointlyOwned     bool
+	// JointlyOwnedWithSpouse is what goes on line 3(c), which only asks about accounts held jointly with a spouse
+	JointlyOwnedWithSpouse bool
+	MaximumValueUSD        int
+	MaximumValueRange      string
+	YearEndValueUSD        int
+	ExchangeRate           fbar.ExchangeRate
+	InstitutionName        string
+	InstitutionAddress     string
+}
+
+// FromReport works out the Form 8938 position for the Up accounts in an FBAR report. Like the FBAR threshold, the
+// 8938 thresholds apply across all specified foreign financial assets, so a "not required" verdict only means that Up
+// accounts alone don't require it. Accounts left out of the report by fbar.WithAccountFilter still count towards the
+// verdict and Part I, but only those in the report get Part V entries
+func FromReport(r *fbar.Report, opts Options) (*Summary, error) {
+	thresholds, err := ThresholdsFor(opts.FilingStatus, opts.LivingAbroad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine thresholds: %w", err)
+	}
+
+	s := &Summary{
+		TaxYear:    r.FinancialYear,
+		Options:    opts,
+		Thresholds: thresholds,
+	}
+
+	loc := r.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	for _, entry := range r.AllEntries() {
+		joint := entry.Ownership == upapi.OwnershipTypeJoint
+		acc := Account{
+			AccountName:            entry.AccountName,
+			DepositAccount:         true, // every Up account is a deposit account
+			AccountNumber:          opts.AccountNumbers[entry.AccountName],
+			OpenedDuringYear:       entry.OpenedAt.In(loc).Year() == r.FinancialYear,
+			JointlyOwned:           joint,
+			JointlyOwnedWithSpouse: joint && opts.JointAccountsWithSpouse,
+			MaximumValueUSD:        entry.HighWaterMarkUSD,
+			MaximumValueRange:      maximumValueRange(entry.HighWaterMarkUSD),
+			YearEndValueUSD:        r.ExchangeRate.ToUSD(entry.ClosingBalance),
+			ExchangeRate:           r.ExchangeRate,
+			InstitutionName:        fbar.UpInstitutionName,
+			InstitutionAddress:     institutionAddress,
+		}
+		if _, shown := r.Entries[entry.AccountName]; shown {
+			s.Accounts = append(s.Accounts, acc)
+		}
+
+		s.PartI.DepositAccountCount++
+		s.PartI.MaximumValueUSD += acc.MaximumValueUSD
+
+		yearEnd, maximum := acc.YearEndValueUSD, acc.MaximumValueUSD
+		if acc.JointlyOwnedWithSpouse && opts.FilingStatus == FilingStatusMarriedFilingSeparately {
+			yearEnd, maximum = yearEnd/2, maximum/2
+		}
+
+		s.YearEndTotalUSD += yearEnd
+		s.MaximumTotalUSD += maximum
+	}
+
+	s.Required = s.YearEndTotalUSD > thresholds.YearEndUSD || s.MaximumTotalUSD > thresholds.AnyTimeUSD
+
+	return s, nil
+}
+
+// institutionAddress is the Part V line 8 mailing address of the institution that holds Up accounts
+var institutionAddress = fmt.Sprintf("%s, %s %s %s, Australia", fbar.UpInstitutionStreet, fbar.UpInstitutionCity,
+	fbar.UpInstitutionState, fbar.UpInstitutionZIP)
+
+// maximumValueRange returns the Part V line 4 checkbox for a maximum value
+func maximumValueRange(usd int) string {
+	switch {
+	case usd <= 50_000:
+		return "(a) $0-$50,000"
+	case usd <= 100_000:
+		return "(b) $50,001-$100,000"
+	case usd <= 150_000:
+		return "(c) $100,001-$150,000"
+	case usd <= 200_000:
+		return "(d) $150,001-$200,000"
+	default:
+		return fmt.Sprintf("(e) %s", fbar.PrettyUSD(usd))
+	}
+}
+
+func (s *Summary) PrettyString() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Form 8938 summary for Upbank, TY%d\n\n", s.TaxYear))
+
+	verdict := "Form 8938 NOT required based on Up accounts alone"
+	if s.Required {
+		verdict = "Form 8938 REQUIRED"
+	}
+	sb.WriteString(fmt.Sprintf("%s:\n", verdict))
+	sb.WriteString(fmt.Sprintf("\tYear end value: %s vs threshold %s\n", fbar.PrettyUSD(s.YearEndTotalUSD), fbar.PrettyUSD(s.Thresholds.YearEndUSD)))
+	sb.WriteString(fmt.Sprintf("\tMaximum value: %s vs threshold %s\n", fbar.PrettyUSD(s.MaximumTotalUSD), fbar.PrettyUSD(s.Thresholds.AnyTimeUSD)))
+	sb.WriteString("\n")
+
+	sb.WriteString("Part I, Foreign Deposit and Custodial Accounts Summary:\n")
+	sb.WriteString(fmt.Sprintf("\tLine 1, number of deposit accounts: %d\n", s.PartI.DepositAccountCount))
+	sb.WriteString(fmt.Sprintf("\tLine 2, maximum value of all deposit accounts: %s\n", fbar.PrettyUSD(s.PartI.MaximumValueUSD)))
+	sb.WriteString("\n")
+
+	for _, acc := range s.Accounts {
+		sb.WriteString(fmt.Sprintf("Part V, %s:\n", acc.AccountName))
+		sb.WriteString("\tLine 1, type of account: Deposit\n")
+		sb.WriteString(fmt.Sprintf("\tLine 2, account number: %s\n", accountNumber(acc.AccountNumber)))
+		sb.WriteString(fmt.Sprintf("\tLine 3(a), account opened during tax year: %s\n", yesNo(acc.OpenedDuringYear)))
+		sb.WriteString(fmt.Sprintf("\tLine 3(b), account closed during tax year: %s\n", yesNo(acc.ClosedDuringYear)))
+		sb.WriteString(fmt.Sprintf("\tLine 3(c), account jointly owned with spouse: %s\n", yesNo(acc.JointlyOwnedWithSpouse)))
+		sb.WriteString(fmt.Sprintf("\tLine 4, maximum value during tax year: %s\n", acc.MaximumValueRange))
+		sb.WriteString("\tLine 5, used foreign currency exchange rate: Yes\n")
+		sb.WriteString(fmt.Sprintf("\tLine 6, exchange rate: %s, source: %s\n", acc.ExchangeRate.Rate.FloatString(3), ExchangeRateSource))
+		sb.WriteString(fmt.Sprintf("\tLine 7, name of financial institution: %s\n", acc.InstitutionName))
+		sb.WriteString(fmt.Sprintf("\tLine 8, address of financial institution: %s\n", acc.InstitutionAddress))
+		sb.WriteString(fmt.Sprintf("\tYear end value: %s\n", fbar.PrettyUSD(acc.YearEndValueUSD)))
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func accountNumber(n string) string {
+	if n == "" {
+		return "unknown, add it to the filer profile's account_numbers"
+	}
+	return n
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+	return "No"
+}
@@ -0,0 +1,125 @@
+package form8938
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+func TestFromReport(t *testing.T) {
+	r := &fbar.Report{
+		FinancialYear: 2023,
+		ExchangeRate: fbar.ExchangeRate{
+			RecordDate: time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC),
+			Currency:   fbar.CurrencyAUD,
+			Rate:       big.NewRat(3, 2),
+		},
+		Entries: map[string]fbar.ReportEntry{
+			"Spending": {AccountName: "Spending", Ownership: upapi.OwnershipTypeIndividual, HighWaterMarkUSD: 40_000, ClosingBalance: 1_500_000_00},
+			"Together": {AccountName: "Together", Ownership: upapi.OwnershipTypeJoint, HighWaterMarkUSD: 40_000, ClosingBalance: 0},
+		},
+	}
+
+	s, err := FromReport(r, Options{FilingStatus: FilingStatusSingle})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !s.Required {
+		t.Errorf("expected 8938 to be required for a single filer with $80,000 maximum value, but it wasn't")
+	}
+
+	if s.YearEndTotalUSD != 1_000_000 {
+		t.Errorf("expected year end total to be 1000000, got %d", s.YearEndTotalUSD)
+	}
+
+	if s.PartI.DepositAccountCount != 2 || s.PartI.MaximumValueUSD != 80_000 {
+		t.Errorf("expected part I to have 2 accounts worth $80,000, got %+v", s.PartI)
+	}
+
+	// Accounts left out of the report still count towards the thresholds, but don't get Part V entries
+	r.Filtered = map[string]fbar.ReportEntry{"Together": r.Entries["Together"]}
+	delete(r.Entries, "Together")
+	s, err = FromReport(r, Options{FilingStatus: FilingStatusSingle})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !s.Required || s.PartI.DepositAccountCount != 2 || len(s.Accounts) != 1 {
+		t.Errorf("expected the filtered out account to count but not be listed, got %+v", s)
+	}
+
+	r.Entries["Together"] = r.Filtered["Together"]
+	r.Filtered = nil
+	r.Entries["Spending"] = fbar.ReportEntry{AccountName: "Spending", Ownership: upapi.OwnershipTypeIndividual, HighWaterMarkUSD: 40_000}
+	s, err = FromReport(r, Options{FilingStatus: FilingStatusSingle, LivingAbroad: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.Required {
+		t.Errorf("expected 8938 not to be required for a single filer living abroad with $80,000 maximum value, but it was")
+	}
+}
+
+func TestFromReportPartV(t *testing.T) {
+	r := &fbar.Report{
+		FinancialYear: 2023,
+		Location:      time.UTC,
+		ExchangeRate:  fbar.ExchangeRate{Currency: fbar.CurrencyAUD, Rate: big.NewRat(3, 2)},
+		Entries: map[string]fbar.ReportEntry{
+			"Spending": {AccountName: "Spending", Ownership: upapi.OwnershipTypeIndividual, OpenedAt: time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC)},
+			"Together": {AccountName: "Together", Ownership: upapi.OwnershipTypeJoint, OpenedAt: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	s, err := FromReport(r, Options{
+		FilingStatus:            FilingStatusMarriedFilingJointly,
+		JointAccountsWithSpouse: true,
+		AccountNumbers:          map[string]string{"Spending": "123456789"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	accounts := map[string]Account{}
+	for _, acc := range s.Accounts {
+		accounts[acc.AccountName] = acc
+	}
+
+	if spending := accounts["Spending"]; spending.AccountNumber != "123456789" || spending.OpenedDuringYear || spending.JointlyOwnedWithSpouse {
+		t.Errorf("expected spending to have its account number, not be opened during the year nor be joint with a spouse, got %+v", spending)
+	}
+
+	if together := accounts["Together"]; together.AccountNumber != "" || !together.OpenedDuringYear || !together.JointlyOwnedWithSpouse {
+		t.Errorf("expected together to have no account number, be opened during the year and be joint with a spouse, got %+v", together)
+	}
+
+	if acc := accounts["Spending"]; acc.InstitutionName != fbar.UpInstitutionName || !strings.Contains(acc.InstitutionAddress, "Bendigo VIC 3550") {
+		t.Errorf("expected the institution to be Bendigo and Adelaide Bank, got %q at %q", acc.InstitutionName, acc.InstitutionAddress)
+	}
+
+	// Joint accounts held with someone other than a spouse don't go on line 3(c)
+	s, err = FromReport(r, Options{FilingStatus: FilingStatusSingle})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := s.PrettyString()
+	if strings.Contains(out, "jointly owned with spouse: Yes") {
+		t.Errorf("expected no account to be jointly owned with a spouse, got %q", out)
+	}
+	if !strings.Contains(out, "Line 3(a), account opened during tax year: Yes") {
+		t.Errorf("expected an account opened during the tax year, got %q", out)
+	}
+}
+
+func TestFromReportUnknownStatus(t *testing.T) {
+	if _, err := FromReport(&fbar.Report{}, Options{FilingStatus: "vibes"}); err == nil {
+		t.Errorf("expected an error for an unknown filing status, got nil")
+	}
+}
@@ -2,7 +2,9 @@ package ledger
 
 import (
 	"fmt"
+	"iter"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"time"
@@ -13,8 +15,19 @@ import (
 
 type Ledger struct {
 	CurrentBalance int
-	AccountName    string
-	Entries        []Entry
+	// StartingBalance is the balance before the first entry. It's always zero for ledgers built forwards, but for
+	// ledgers anchored to the current balance it's whatever is left over after walking back through every transaction
+	StartingBalance int
+	AccountName     string
+	Entries         []Entry
+	// Held is every transaction that was still held when the ledger was built, whether or not it's counted in Entries
+	Held []Entry
+	// Location is the timezone that decides which year an entry falls in. If it's nil, each entry's own timestamp
+	// decides, which for the Up API means Australian eastern time
+	Location *time.Location
+
+	// heldExcluded is the total of held transactions left out of Entries
+	heldExcluded int
 }
 
 type Money int
@@ -23,62 +36,312 @@ func (m Money) MarshalCSV() (string, error) {
 	return fmt.Sprintf("%.2f", float64(m)/100), nil
 }
 
+// Tags are the labels on a transaction
+type Tags []string
+
+func (t Tags) MarshalCSV() (string, error) {
+	return strings.Join(t, ";"), nil
+}
+
 type Entry struct {
-	ID string `json:"id" csv:"id"`
+	ID     string `json:"id" csv:"id"`
+	Status string `json:"status" csv:"status"`
 
 	CreatedAt time.Time  `json:"created_at" csv:"created_at"`
 	SettledAt *time.Time `json:"settled_at" csv:"settled_at"`
+	// EffectiveAt is the time the entry is counted at for ordering and year attribution, which is either when it was
+	// created or when it settled depending on how the ledger was built
+	EffectiveAt time.Time `json:"effective_at" csv:"effective_at"`
 
 	Description string  `json:"description" csv:"description"`
 	Message     *string `json:"message" csv:"message"`
 
 	Amount       Money `json:"amount" csv:"amount"`
 	BalanceAfter Money `json:"balance_after" csv:"balance_after"`
+
+	// RoundUp is the part of Amount that was rounded up and moved to a saver
+	RoundUp Money `json:"round_up" csv:"round_up"`
+	// AccountID is the account the entry belongs to, and TransferAccountID the other account involved if this is a
+	// transfer between Up accounts
+	AccountID         string `json:"account_id" csv:"account_id"`
+	TransferAccountID string `json:"transfer_account_id" csv:"transfer_account_id"`
+
+	CategoryID       string `json:"category_id" csv:"category_id"`
+	ParentCategoryID string `json:"parent_category_id" csv:"parent_category_id"`
+	Tags             Tags   `json:"tags" csv:"tags"`
+	// Label is set by MatchTransfers on entries that move money between the household's own accounts
+	Label Label `json:"label" csv:"label"`
+}
+
+type config struct {
+	excludeHeld bool
+	bySettled   bool
+	location    *time.Location
+}
+
+type Option func(*config)
+
+// WithExcludeHeld leaves transactions that are still held out of the ledger's balances. Holds can change amount or
+// disappear entirely before they settle, so counting them can inflate the high water mark
+func WithExcludeHeld() Option {
+	return func(c *config) {
+		c.excludeHeld = true
+	}
+}
+
+// WithSettlementDates orders entries and attributes them to years by when they settled rather than when they were
+// created. Held transactions, which haven't settled, fall back to when they were created
+func WithSettlementDates() Option {
+	return func(c *config) {
+		c.bySettled = true
+	}
+}
+
+// WithLocation sets the timezone used to bucket entries into years. Entry timestamps are converted into it too, so that
+// CSVs show local times
+func WithLocation(loc *time.Location) Option {
+	return func(c *config) {
+		c.location = loc
+	}
+}
+
+// FromTransactions builds a ledger by summing transactions forwards in time from a zero balance. Transactions are
+// expected newest first, as the Up API returns them
+func FromTransactions(accountName string, xacts []upapi.Transaction, opts ...Option) *Ledger {
+	ledger, _ := FromTransactionSeq(accountName, Seq(xacts), opts...)
+	return ledger
 }
 
-func FromTransactions(accountName string, xacts []upapi.Transaction) *Ledger {
+// FromTransactionSeq is FromTransactions for a stream of transactions, such as from upapi.Client.TransactionsForAccount.
+// It stops at the first error in the stream
+func FromTransactionSeq(accountName string, xacts iter.Seq2[upapi.Transaction, error], opts ...Option) (*Ledger, error) {
 	ledger := &Ledger{AccountName: accountName}
+	if err := ledger.collect(xacts, opts); err != nil {
+		return nil, err
+	}
+
+	for i := range ledger.Entries {
+		ledger.CurrentBalance += int(ledger.Entries[i].Amount)
+		ledger.Entries[i].BalanceAfter = Money(ledger.CurrentBalance)
+	}
 
-	slices.Reverse(xacts)
-	for _, xact := range xacts {
-		amount := xact.Attributes.Amount.ValueInBaseUnits
+	return ledger, nil
+}
+
+// FromTransactionsAnchored builds a ledger by starting at the account's current balance and walking transactions
+// backwards in time. Balances at any point then only depend on the transactions after it, so a transaction missing
+// from years ago can't shift this year's figures. Transactions are expected newest first, as the Up API returns them
+func FromTransactionsAnchored(accountName string, currentBalance int, xacts []upapi.Transaction, opts ...Option) *Ledger {
+	ledger, _ := FromTransactionSeqAnchored(accountName, currentBalance, Seq(xacts), opts...)
+	return ledger
+}
 
-		if xact.Attributes.RoundUp != nil {
-			amount += xact.Attributes.RoundUp.Amount.ValueInBaseUnits
+// FromTransactionSeqAnchored is FromTransactionsAnchored for a stream of transactions. It stops at the first error in
+// the stream
+func FromTransactionSeqAnchored(accountName string, currentBalance int, xacts iter.Seq2[upapi.Transaction, error], opts ...Option) (*Ledger, error) {
+	ledger := &Ledger{AccountName: accountName}
+	if err := ledger.collect(xacts, opts); err != nil {
+		return nil, err
+	}
+
+	// Up's balance includes held transactions, so take out any we're not counting
+	ledger.CurrentBalance = currentBalance - ledger.heldExcluded
+
+	balance := ledger.CurrentBalance
+	for i := len(ledger.Entries) - 1; i >= 0; i-- {
+		ledger.Entries[i].BalanceAfter = Money(balance)
+		balance -= int(ledger.Entries[i].Amount)
+	}
+	ledger.StartingBalance = balance
+
+	return ledger, nil
+}
+
+// collect fills in the ledger's entries, oldest first, without balances
+func (l *Ledger) collect(xacts iter.Seq2[upapi.Transaction, error], opts []Option) error {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	l.Location = cfg.location
+
+	for xact, err := range xacts {
+		if err != nil {
+			return err
 		}
 
-		if xact.Attributes.Cashback != nil {
-			amount += xact.Attributes.Cashback.Amount.ValueInBaseUnits
+		entry := newEntry(xact, amountOf(xact), cfg.location)
+		if cfg.bySettled && entry.SettledAt != nil {
+			entry.EffectiveAt = *entry.SettledAt
 		}
 
-		ledger.Entries = append(ledger.Entries, Entry{
-			ID: xact.ID,
+		if entry.Status == upapi.TransactionStatusHeld {
+			l.Held = append(l.Held, entry)
 
-			CreatedAt: xact.Attributes.CreatedAt,
-			SettledAt: xact.Attributes.SettledAt,
+			if cfg.excludeHeld {
+				l.heldExcluded += int(entry.Amount)
+				continue
+			}
+		}
 
-			Description: xact.Attributes.Description,
-			Message:     xact.Attributes.Message,
+		l.Entries = append(l.Entries, entry)
+	}
 
-			Amount:       Money(amount),
-			BalanceAfter: Money(ledger.CurrentBalance + amount),
-		})
+	// Transactions arrive newest first by creation time, but settlement can reorder them
+	slices.Reverse(l.Entries)
+	slices.SortStableFunc(l.Entries, func(i, j Entry) int {
+		return i.EffectiveAt.Compare(j.EffectiveAt)
+	})
 
-		ledger.CurrentBalance += amount
+	return nil
+}
+
+// Seq adapts a slice of transactions to the sequences taken by FromTransactionSeq and FromTransactionSeqAnchored
+func Seq(xacts []upapi.Transaction) iter.Seq2[upapi.Transaction, error] {
+	return func(yield func(upapi.Transaction, error) bool) {
+		for _, xact := range xacts {
+			if !yield(xact, nil) {
+				return
+			}
+		}
 	}
+}
 
-	return ledger
+// amountOf returns the total effect of a transaction on the account's balance
+func amountOf(xact upapi.Transaction) int {
+	amount := xact.Attributes.Amount.ValueInBaseUnits + roundUpOf(xact)
+
+	if xact.Attributes.Cashback != nil {
+		amount += xact.Attributes.Cashback.Amount.ValueInBaseUnits
+	}
+
+	return amount
 }
 
+func newEntry(xact upapi.Transaction, amount int, loc *time.Location) Entry {
+	createdAt, settledAt := xact.Attributes.CreatedAt, xact.Attributes.SettledAt
+	if loc != nil {
+		createdAt = createdAt.In(loc)
+		if settledAt != nil {
+			local := settledAt.In(loc)
+			settledAt = &local
+		}
+	}
+
+	return Entry{
+		ID:     xact.ID,
+		Status: xact.Attributes.Status,
+
+		CreatedAt:   createdAt,
+		SettledAt:   settledAt,
+		EffectiveAt: createdAt,
+
+		Description: xact.Attributes.Description,
+		Message:     xact.Attributes.Message,
+
+		Amount: Money(amount),
+
+		RoundUp:           Money(roundUpOf(xact)),
+		AccountID:         xact.AccountID(),
+		TransferAccountID: xact.TransferAccountID(),
+		CategoryID:        xact.CategoryID(),
+		ParentCategoryID:  xact.ParentCategoryID(),
+		Tags:              Tags(xact.TagIDs()),
+	}
+}
+
+func roundUpOf(xact upapi.Transaction) int {
+	if xact.Attributes.RoundUp == nil {
+		return 0
+	}
+
+	return xact.Attributes.RoundUp.Amount.ValueInBaseUnits
+}
+
+// ReconciliationError is returned when a ledger reconstructed from transactions doesn't match the balance Up reports
+// for the account, which means transactions are missing or have been counted twice
+type ReconciliationError struct {
+	AccountName   string
+	Reconstructed int
+	Actual        int
+}
+
+func (e *ReconciliationError) Error() string {
+	return fmt.Sprintf("reconstructed balance for %s is %.2f but Up reports %.2f (offset %.2f)",
+		e.AccountName, float64(e.Reconstructed)/100, float64(e.Actual)/100, float64(e.Offset())/100)
+}
+
+// Offset is how far the reconstructed balance is from the actual balance
+func (e *ReconciliationError) Offset() int {
+	return e.Reconstructed - e.Actual
+}
+
+// Reconcile checks the sum of every transaction in the ledger against the balance reported by Up. The ledger must
+// have been built from the account's full transaction history for this to be meaningful
+func (l *Ledger) Reconcile(actual int) error {
+	reconstructed := l.CurrentBalance - l.StartingBalance + l.heldExcluded
+	if reconstructed == actual {
+		return nil
+	}
+
+	return &ReconciliationError{
+		AccountName:   l.AccountName,
+		Reconstructed: reconstructed,
+		Actual:        actual,
+	}
+}
+
+// HighWaterMark returns the highest balance the account held during the given year. The balance carried into the year
+// counts, so an account that had no transactions during the year has a high water mark of its opening balance
 func (l *Ledger) HighWaterMark(year int) int {
-	hwm := Money(0)
+	hwm := Money(l.OpeningBalance(year))
+
+	for _, entry := range l.Entries {
+		if l.yearOf(entry.EffectiveAt) == year && entry.BalanceAfter > hwm {
+			hwm = entry.BalanceAfter
+		}
+	}
+
+	return int(hwm)
+}
 
-	if len(l.Entries) != 0 && len(l.TransactionsForYear(year)) == 0 {
-		panic(fmt.Sprintf("MISSING FUNCTIONALITY: Attempting to calculate high watermark for account %s in year %d. %s has had transactions, but none this year. Please fix this, ben", l.AccountName, year, l.AccountName))
+// OpeningBalance returns the balance carried into the given year, ie the balance after the last entry before it
+func (l *Ledger) OpeningBalance(year int) int {
+	balance := Money(l.StartingBalance)
+	for _, entry := range l.Entries {
+		if l.yearOf(entry.EffectiveAt) >= year {
+			break
+		}
+		balance = entry.BalanceAfter
+	}
+
+	return int(balance)
+}
+
+// BalanceAt returns the balance immediately before the given time
+func (l *Ledger) BalanceAt(t time.Time) int {
+	balance := Money(l.StartingBalance)
+	for _, entry := range l.Entries {
+		if !entry.EffectiveAt.Before(t) {
+			break
+		}
+		balance = entry.BalanceAfter
 	}
 
+	return int(balance)
+}
+
+// HighWaterMarkBetween returns the highest balance held from start up to (but not including) end, which is the larger
+// of the balance carried in at start and every balance in between
+func (l *Ledger) HighWaterMarkBetween(start, end time.Time) int {
+	hwm := Money(l.BalanceAt(start))
+
 	for _, entry := range l.Entries {
-		if entry.CreatedAt.Year() == year && entry.BalanceAfter > hwm {
+		if entry.EffectiveAt.Before(start) || !entry.EffectiveAt.Before(end) {
+			continue
+		}
+
+		if entry.BalanceAfter > hwm {
 			hwm = entry.BalanceAfter
 		}
 	}
@@ -86,10 +349,24 @@ func (l *Ledger) HighWaterMark(year int) int {
 	return int(hwm)
 }
 
+// Dormant returns whether the account had history before the given year, but no transactions during it
+func (l *Ledger) Dormant(year int) bool {
+	return len(l.Entries) != 0 && l.yearOf(l.Entries[0].EffectiveAt) < year && len(l.TransactionsForYear(year)) == 0
+}
+
+// yearOf returns the year a time falls in, in the ledger's timezone
+func (l *Ledger) yearOf(t time.Time) int {
+	if l.Location != nil {
+		t = t.In(l.Location)
+	}
+
+	return t.Year()
+}
+
 func (l *Ledger) TransactionsForYear(year int) []Entry {
 	var xacts []Entry
 	for _, entry := range l.Entries {
-		if entry.CreatedAt.Year() == year {
+		if l.yearOf(entry.EffectiveAt) == year {
 			xacts = append(xacts, entry)
 		}
 	}
@@ -97,8 +374,30 @@ func (l *Ledger) TransactionsForYear(year int) []Entry {
 	return xacts
 }
 
-func (l *Ledger) DumpCSV(year int) error {
-	name := "./" + strings.ReplaceAll(l.AccountName, " ", "-") + ".csv"
+// SpendByCategory totals the money spent between start and end by category ID, in cents. Uncategorised spending is
+// keyed by the empty string. Transfers between the household's accounts and round-ups aren't spending, so they're
+// left out once MatchTransfers has labelled them
+func (l *Ledger) SpendByCategory(start, end time.Time) map[string]int {
+	spend := make(map[string]int)
+	for _, entry := range l.Entries {
+		if entry.EffectiveAt.Before(start) || !entry.EffectiveAt.Before(end) {
+			continue
+		}
+
+		// Round-ups are folded into Amount, but they're savings rather than spending
+		amount := int(entry.Amount - entry.RoundUp)
+		if entry.Label != "" || amount >= 0 {
+			continue
+		}
+		spend[entry.CategoryID] -= amount
+	}
+
+	return spend
+}
+
+// DumpCSV writes the entries for the given year to a CSV named after the account, in dir
+func (l *Ledger) DumpCSV(dir string, year int) error {
+	name := filepath.Join(dir, strings.ReplaceAll(l.AccountName, " ", "-")+".csv")
 	f, err := os.Create(name)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
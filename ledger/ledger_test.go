@@ -0,0 +1,184 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+func xact(id string, createdAt time.Time, amount int) upapi.Transaction {
+	x := upapi.Transaction{ID: id}
+	x.Attributes.CreatedAt = createdAt
+	x.Attributes.Amount.ValueInBaseUnits = amount
+	return x
+}
+
+func TestHighWaterMarkDormant(t *testing.T) {
+	// The API returns transactions newest first
+	l := FromTransactions("Saver", []upapi.Transaction{
+		xact("3", time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC), -200),
+		xact("2", time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC), 500),
+		xact("1", time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC), 1000),
+	})
+
+	if !l.Dormant(2023) {
+		t.Errorf("expected account to be dormant in 2023, but it wasn't")
+	}
+
+	if hwm := l.HighWaterMark(2023); hwm != 1300 {
+		t.Errorf("expected high water mark in 2023 to be the carried forward balance of 1300, got %d", hwm)
+	}
+
+	if l.Dormant(2022) {
+		t.Errorf("expected account not to be dormant in 2022, but it was")
+	}
+
+	if hwm := l.HighWaterMark(2022); hwm != 1500 {
+		t.Errorf("expected high water mark in 2022 to be 1500, got %d", hwm)
+	}
+
+	if l.Dormant(2020) {
+		t.Errorf("expected account not to be dormant before it had any history, but it was")
+	}
+}
+
+func TestBalancesBetween(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, sydney)
+	end := time.Date(2024, time.January, 1, 0, 0, 0, 0, sydney)
+
+	l := FromTransactions("Spending", []upapi.Transaction{
+		xact("4", end, 10000),
+		xact("3", end.Add(-time.Second), -500),
+		xact("2", start, 100),
+		xact("1", start.Add(-time.Second), 2000),
+	})
+
+	if got := l.BalanceAt(start); got != 2000 {
+		t.Errorf("expected opening balance to be 2000, got %d", got)
+	}
+
+	if got := l.BalanceAt(end); got != 1600 {
+		t.Errorf("expected closing balance to be 1600, got %d", got)
+	}
+
+	if got := l.HighWaterMarkBetween(start, end); got != 2100 {
+		t.Errorf("expected high water mark to be 2100, got %d", got)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	l := FromTransactions("Spending", []upapi.Transaction{
+		xact("2", time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC), -250),
+		xact("1", time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), 1000),
+	})
+
+	if err := l.Reconcile(750); err != nil {
+		t.Errorf("expected ledger to reconcile, got %v", err)
+	}
+
+	err := l.Reconcile(1000)
+	var rErr *ReconciliationError
+	if !errors.As(err, &rErr) {
+		t.Fatalf("expected a ReconciliationError, got %v", err)
+	}
+
+	if rErr.Offset() != -250 {
+		t.Errorf("expected offset to be -250, got %d", rErr.Offset())
+	}
+}
+
+func TestFromTransactionsAnchored(t *testing.T) {
+	// The transaction that brought the balance up to 1000 is missing
+	l := FromTransactionsAnchored("Spending", 1500, []upapi.Transaction{
+		xact("3", time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC), 700),
+		xact("2", time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC), -200),
+	})
+
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if got := l.BalanceAt(start); got != 1000 {
+		t.Errorf("expected opening balance to be 1000, got %d", got)
+	}
+
+	if got := l.HighWaterMarkBetween(start, start.AddDate(1, 0, 0)); got != 1500 {
+		t.Errorf("expected high water mark to be 1500, got %d", got)
+	}
+
+	var rErr *ReconciliationError
+	if err := l.Reconcile(1500); !errors.As(err, &rErr) || rErr.Offset() != -1000 {
+		t.Errorf("expected a reconciliation error with offset -1000, got %v", err)
+	}
+}
+
+func TestHeldTransactions(t *testing.T) {
+	held := xact("3", time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC), 5000)
+	held.Attributes.Status = upapi.TransactionStatusHeld
+
+	// Made at the end of 2022, but didn't settle until 2023
+	lateSettler := xact("2", time.Date(2022, time.December, 31, 0, 0, 0, 0, time.UTC), -100)
+	settledAt := time.Date(2023, time.January, 2, 0, 0, 0, 0, time.UTC)
+	lateSettler.Attributes.SettledAt = &settledAt
+
+	xacts := []upapi.Transaction{
+		held,
+		lateSettler,
+		xact("1", time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC), 1000),
+	}
+
+	l := FromTransactions("Spending", xacts, WithExcludeHeld(), WithSettlementDates())
+
+	if len(l.Held) != 1 || len(l.Entries) != 2 {
+		t.Errorf("expected 1 held transaction and 2 entries, got %d and %d", len(l.Held), len(l.Entries))
+	}
+
+	if hwm := l.HighWaterMark(2023); hwm != 1000 {
+		t.Errorf("expected the excluded hold not to count towards the 2023 high water mark of 1000, got %d", hwm)
+	}
+
+	if n := len(l.TransactionsForYear(2023)); n != 1 {
+		t.Errorf("expected the late settling transaction to count in 2023, got %d transactions", n)
+	}
+
+	if err := l.Reconcile(5900); err != nil {
+		t.Errorf("expected the ledger to reconcile including the excluded hold, got %v", err)
+	}
+
+	anchored := FromTransactionsAnchored("Spending", 5900, xacts, WithExcludeHeld())
+	if anchored.CurrentBalance != 900 || anchored.StartingBalance != 0 {
+		t.Errorf("expected anchored ledger to end at 900 from 0, got %d from %d", anchored.CurrentBalance, anchored.StartingBalance)
+	}
+}
+
+func TestWithLocation(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Just after midnight on New Year's Day in Sydney, but still New Year's Eve in New York
+	xacts := []upapi.Transaction{xact("1", time.Date(2024, time.January, 1, 0, 30, 0, 0, sydney), 1000)}
+
+	if n := len(FromTransactions("Spending", xacts).TransactionsForYear(2024)); n != 1 {
+		t.Errorf("expected the transaction to fall in 2024 by its own timestamp, got %d transactions", n)
+	}
+
+	l := FromTransactions("Spending", xacts, WithLocation(newYork))
+	if n := len(l.TransactionsForYear(2023)); n != 1 {
+		t.Errorf("expected the transaction to fall in 2023 in New York, got %d transactions", n)
+	}
+
+	if l.Entries[0].CreatedAt.Location() != newYork {
+		t.Errorf("expected entry times to be converted to New York, got %s", l.Entries[0].CreatedAt.Location())
+	}
+}
@@ -0,0 +1,263 @@
+package ledger
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Label string
+
+const (
+	LabelInternalTransfer Label = "internal_transfer"
+	LabelRoundUp          Label = "round_up"
+	// LabelUnmatchedTransfer marks entries that look like transfers between the household's accounts, but whose other
+	// side couldn't be found
+	LabelUnmatchedTransfer Label = "unmatched_transfer"
+)
+
+// TagInternalTransfer is the Up tag for marking a transaction as money moving between the household's accounts when
+// Up's description doesn't make that obvious. Tagged transactions are matched like any other transfer
+const TagInternalTransfer = "internal-transfer"
+
+// transferWindow is how far apart in time the two sides of a transfer can be
+const transferWindow = 24 * time.Hour
+
+// untaggedTransferWindow is how far apart the two sides of a transfer can be when Up hasn't said which account the
+// money went to, and all we have to go on is the amount and time
+const untaggedTransferWindow = time.Minute
+
+// transferPrefixes are how Up describes transfers between accounts, for matching when it hasn't linked the accounts
+var transferPrefixes = []string{"Transfer to ", "Transfer from ", "Round Up", "Quick save transfer", "Cover to ", "Cover from "}
+
+// Transfer is money moving between two of the household's accounts. Amount is always positive
+type Transfer struct {
+	Label         Label
+	FromAccountID string
+	ToAccountID   string
+	Amount        int
+	// DebitID and CreditID are the IDs of the entries on each side. One of them is empty if the transfer is unmatched
+	DebitID  string
+	CreditID string
+	At       time.Time
+}
+
+func (t Transfer) Matched() bool {
+	return t.DebitID != "" && t.CreditID != ""
+}
+
+type entryRef struct {
+	accountID string
+	idx       int
+}
+
+// MatchTransfers finds money moving between the given ledgers, keyed by account ID, between start and end, and labels
+// the entries on both sides. Round-ups are matched first, then transfers Up has linked to another account, then
+// anything that looks like a transfer by its description, amount and time. Entries between start and end that look
+// like transfers but can't be matched are labelled LabelUnmatchedTransfer and returned as transfers with one side
+// missing. Entries just outside the period are only used as the other side of transfers inside it
+func MatchTransfers(ledgers map[string]*Ledger, start, end time.Time) []Transfer {
+	var transfers []Transfer
+	matched := make(map[entryRef]bool)
+
+	accountIDs := slices.Sorted(maps.Keys(ledgers))
+
+	// Only entries within a transfer window of the period can be part of a transfer in it, so there's no need to look
+	// through the rest of each account's history
+	candidates := make(map[string][]int, len(ledgers))
+	for _, id := range accountIDs {
+		for i, e := range ledgers[id].Entries {
+			if !e.EffectiveAt.Before(start.Add(-transferWindow)) && e.EffectiveAt.Before(end.Add(transferWindow)) {
+				candidates[id] = append(candidates[id], i)
+			}
+		}
+	}
+
+	link := func(label Label, debit, credit entryRef) {
+		matched[debit], matched[credit] = true, true
+
+		d := &ledgers[debit.accountID].Entries[debit.idx]
+		c := &ledgers[credit.accountID].Entries[credit.idx]
+		c.Label = label
+		if label != LabelRoundUp {
+			// The debit side of a round-up is the purchase it rounded up, which is still spending
+			d.Label = label
+		}
+
+		amount := int(c.Amount)
+		transfers = append(transfers, Transfer{
+			Label:         label,
+			FromAccountID: debit.accountID,
+			ToAccountID:   credit.accountID,
+			Amount:        amount,
+			DebitID:       d.ID,
+			CreditID:      c.ID,
+			At:            d.EffectiveAt,
+		})
+	}
+
+	// findCounterpart looks for an unmatched entry in the given account with the given amount, closest in time to at
+	findCounterpart := func(accountID string, amount Money, at time.Time, window time.Duration, ok func(Entry) bool) (entryRef, bool) {
+		l, exists := ledgers[accountID]
+		if !exists {
+			return entryRef{}, false
+		}
+
+		best, bestGap := -1, time.Duration(0)
+		for _, i := range candidates[accountID] {
+			e := l.Entries[i]
+			if matched[entryRef{accountID, i}] || e.Amount != amount || !ok(e) {
+				continue
+			}
+
+			gap := e.EffectiveAt.Sub(at).Abs()
+			if gap > window {
+				continue
+			}
+
+			if best == -1 || gap < bestGap {
+				best, bestGap = i, gap
+			}
+		}
+
+		return entryRef{accountID, best}, best != -1
+	}
+
+	// Round-ups: a purchase on one account with a round-up, and a credit of the round-up amount on a saver
+	for _, id := range accountIDs {
+		for _, i := range candidates[id] {
+			e := ledgers[id].Entries[i]
+			if e.RoundUp == 0 {
+				continue
+			}
+
+			debit := entryRef{id, i}
+			for _, other := range accountIDs {
+				if other == id {
+					continue
+				}
+
+				credit, ok := findCounterpart(other, -e.RoundUp, e.EffectiveAt, transferWindow, func(c Entry) bool {
+					return c.TransferAccountID == id || (c.TransferAccountID == "" && strings.HasPrefix(c.Description, "Round Up"))
+				})
+				if ok {
+					link(LabelRoundUp, debit, credit)
+					break
+				}
+			}
+		}
+	}
+
+	// Transfers Up has linked to another account
+	for _, id := range accountIDs {
+		for _, i := range candidates[id] {
+			e := ledgers[id].Entries[i]
+			ref := entryRef{id, i}
+			if matched[ref] || e.TransferAccountID == "" || e.Amount >= 0 {
+				continue
+			}
+
+			credit, ok := findCounterpart(e.TransferAccountID, -e.Amount, e.EffectiveAt, transferWindow, func(c Entry) bool {
+				return c.TransferAccountID == id || c.TransferAccountID == ""
+			})
+			if ok {
+				link(LabelInternalTransfer, ref, credit)
+			}
+		}
+	}
+
+	// Anything else that looks like a transfer, matched on amount and time alone
+	for _, id := range accountIDs {
+		for _, i := range candidates[id] {
+			e := ledgers[id].Entries[i]
+			ref := entryRef{id, i}
+			if matched[ref] || e.Amount >= 0 || !looksLikeTransfer(e) {
+				continue
+			}
+
+			for _, other := range accountIDs {
+				if other == id {
+					continue
+				}
+
+				credit, ok := findCounterpart(other, -e.Amount, e.EffectiveAt, untaggedTransferWindow, looksLikeTransfer)
+				if ok {
+					link(LabelInternalTransfer, ref, credit)
+					break
+				}
+			}
+		}
+	}
+
+	// Whatever's left over in the period that looks like a transfer is missing its other side
+	for _, id := range accountIDs {
+		l := ledgers[id]
+		for _, i := range candidates[id] {
+			e := &l.Entries[i]
+			if e.EffectiveAt.Before(start) || !e.EffectiveAt.Before(end) {
+				continue
+			}
+			if matched[entryRef{id, i}] || (e.TransferAccountID == "" && !looksLikeTransfer(*e)) {
+				continue
+			}
+
+			e.Label = LabelUnmatchedTransfer
+			t := Transfer{Label: LabelUnmatchedTransfer, Amount: int(e.Amount), At: e.EffectiveAt}
+			if e.Amount < 0 {
+				t.FromAccountID, t.ToAccountID, t.DebitID, t.Amount = id, e.TransferAccountID, e.ID, -int(e.Amount)
+			} else {
+				t.FromAccountID, t.ToAccountID, t.CreditID = e.TransferAccountID, id, e.ID
+			}
+			transfers = append(transfers, t)
+		}
+	}
+
+	slices.SortStableFunc(transfers, func(i, j Transfer) int {
+		return cmp.Compare(i.At.UnixNano(), j.At.UnixNano())
+	})
+
+	return transfers
+}
+
+func looksLikeTransfer(e Entry) bool {
+	if slices.Contains(e.Tags, TagInternalTransfer) {
+		return true
+	}
+
+	for _, prefix := range transferPrefixes {
+		if strings.HasPrefix(e.Description, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DailyBalance is the combined balance of a set of accounts at the end of a day
+type DailyBalance struct {
+	Date    time.Time
+	Balance int
+}
+
+// CombinedDailyBalances returns the total balance across every ledger at the end of each day from start up to end.
+// Days are in start's timezone. Transfers between the ledgers cancel out, so this is what the household actually held
+func CombinedDailyBalances(ledgers []*Ledger, start, end time.Time) []DailyBalance {
+	var balances []DailyBalance
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		if next.After(end) {
+			next = end
+		}
+
+		total := 0
+		for _, l := range ledgers {
+			total += l.BalanceAt(next)
+		}
+
+		balances = append(balances, DailyBalance{Date: day, Balance: total})
+	}
+
+	return balances
+}
@@ -0,0 +1,88 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+func transfer(id string, at time.Time, amount int, description, toAccountID string) upapi.Transaction {
+	x := xact(id, at, amount)
+	x.Attributes.Description = description
+	if toAccountID != "" {
+		x.Relationships.TransferAccount.Data = &upapi.ResourceIdentifier{Type: "accounts", ID: toAccountID}
+	}
+	return x
+}
+
+func TestMatchTransfers(t *testing.T) {
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	purchase := xact("p", at, -960)
+	purchase.Attributes.RoundUp = &struct {
+		Amount       upapi.Money  `json:"amount"`
+		BoostPortion *upapi.Money `json:"boostPortion,omitempty"`
+	}{Amount: upapi.Money{ValueInBaseUnits: -40}}
+	purchase.Relationships.Category.Data = &upapi.ResourceIdentifier{Type: upapi.ResourceTypeCategories, ID: "groceries"}
+
+	spending := FromTransactions("Spending", []upapi.Transaction{
+		transfer("lost", at.Add(2*time.Hour), -700, "Transfer to Holiday", "holiday"),
+		transfer("last-year", at.AddDate(-1, 0, 0), -500, "Transfer to Holiday", "holiday"),
+		transfer("untagged-out", at.Add(time.Hour), -300, "Transfer to Saver", ""),
+		transfer("out", at, -10000, "Transfer to Saver", "saver"),
+		purchase,
+		xact("salary", at.Add(-time.Hour), 50000),
+	})
+
+	saver := FromTransactions("Saver", []upapi.Transaction{
+		transfer("untagged-in", at.Add(time.Hour+time.Second), 300, "Transfer from Spending", ""),
+		transfer("in", at.Add(time.Second), 10000, "Transfer from Spending", "spending"),
+		transfer("roundup", at.Add(time.Minute), 40, "Round Up", "spending"),
+	})
+
+	transfers := MatchTransfers(map[string]*Ledger{"spending": spending, "saver": saver}, at.AddDate(0, 0, -1), at.AddDate(0, 0, 1))
+
+	byCredit := make(map[string]Transfer)
+	var unmatched []Transfer
+	for _, tr := range transfers {
+		if !tr.Matched() {
+			unmatched = append(unmatched, tr)
+			continue
+		}
+		byCredit[tr.CreditID] = tr
+	}
+
+	if tr := byCredit["roundup"]; tr.Label != LabelRoundUp || tr.DebitID != "p" {
+		t.Errorf("expected round-up to be matched to the purchase, got %+v", tr)
+	}
+
+	if tr := byCredit["in"]; tr.Label != LabelInternalTransfer || tr.DebitID != "out" || tr.Amount != 10000 {
+		t.Errorf("expected linked transfer to be matched, got %+v", tr)
+	}
+
+	if tr := byCredit["untagged-in"]; tr.DebitID != "untagged-out" {
+		t.Errorf("expected untagged transfer to be matched on amount and time, got %+v", tr)
+	}
+
+	if len(unmatched) != 1 || unmatched[0].DebitID != "lost" {
+		t.Errorf("expected only the transfer to Holiday to be unmatched, got %+v", unmatched)
+	}
+
+	for _, e := range spending.Entries {
+		if e.ID == "p" && e.Label != "" {
+			t.Errorf("expected the rounded up purchase to stay unlabelled, got %q", e.Label)
+		}
+	}
+
+	start := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	spend := spending.SpendByCategory(start, start.AddDate(0, 0, 1))
+	if len(spend) != 1 || spend["groceries"] != 960 {
+		t.Errorf("expected only the purchase, less its round-up, to count as spending, got %v", spend)
+	}
+
+	daily := CombinedDailyBalances([]*Ledger{spending, saver}, start, start.AddDate(0, 0, 2))
+	if len(daily) != 2 || daily[0].Balance != 50000-960-700-500 {
+		t.Errorf("expected internal transfers to cancel out in the combined balance, got %+v", daily)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gocarina/gocsv"
+
+	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/ledger"
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+type accountRow struct {
+	ID          string       `json:"id" csv:"id"`
+	DisplayName string       `json:"display_name" csv:"display_name"`
+	AccountType string       `json:"account_type" csv:"account_type"`
+	Ownership   string       `json:"ownership" csv:"ownership"`
+	Balance     ledger.Money `json:"balance" csv:"balance"`
+	CreatedAt   time.Time    `json:"created_at" csv:"created_at"`
+}
+
+func runAccounts(args []string) error {
+	fs := newFlagSet("accounts", "[flags]", "Lists your Up accounts and their current balances.")
+	var common commonFlags
+	var accounts accountFlags
+	common.register(fs)
+	accounts.register(fs)
+	format := fs.String("format", formatText, "output format: text, json or csv")
+	fs.Parse(args)
+
+	if err := accounts.validate(); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatText, formatJSON, formatCSV); err != nil {
+		return err
+	}
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rows := []accountRow{}
+	for acc, err := range client.Accounts(ctx, upapi.ListAccountsParams{}) {
+		if err != nil {
+			return err
+		}
+		if !accounts.keep(acc) {
+			continue
+		}
+
+		rows = append(rows, accountRow{
+			ID:          acc.ID,
+			DisplayName: acc.Attributes.DisplayName,
+			AccountType: acc.Attributes.AccountType,
+			Ownership:   acc.Attributes.OwnershipType,
+			Balance:     ledger.Money(acc.Attributes.Balance.ValueInBaseUnits),
+			CreatedAt:   acc.Attributes.CreatedAt,
+		})
+	}
+
+	return printRows(*format, rows, "ACCOUNT\tTYPE\tOWNERSHIP\tBALANCE\tCREATED\tID", func(r accountRow) string {
+		return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s", r.DisplayName, r.AccountType, r.Ownership, fbar.PrettyMoney(int(r.Balance)), r.CreatedAt.Format(time.DateOnly), r.ID)
+	})
+}
+
+type transactionRow struct {
+	ID          string       `json:"id" csv:"id"`
+	Account     string       `json:"account" csv:"account"`
+	Status      string       `json:"status" csv:"status"`
+	CreatedAt   time.Time    `json:"created_at" csv:"created_at"`
+	SettledAt   *time.Time   `json:"settled_at" csv:"settled_at"`
+	Description string       `json:"description" csv:"description"`
+	Amount      ledger.Money `json:"amount" csv:"amount"`
+	CategoryID  string       `json:"category_id" csv:"category_id"`
+	Tags        ledger.Tags  `json:"tags" csv:"tags"`
+}
+
+func runTransactions(args []string) error {
+	fs := newFlagSet("transactions", "[flags]", "Lists transactions, newest first. By default, every transaction in -year is listed. With -by-category,\nspending is totalled by category instead.")
+	var common commonFlags
+	var accounts accountFlags
+	common.register(fs)
+	accounts.register(fs)
+	var env envDefaults
+	year := fs.Int("year", env.int("YEAR", time.Now().Year()-1), "calendar year to list transactions from ($YEAR, default last year)")
+	since := fs.String("since", "", "list transactions from this date (YYYY-MM-DD) or time (RFC 3339) instead of the start of -year")
+	until := fs.String("until", "", "list transactions before this date (YYYY-MM-DD) or time (RFC 3339) instead of the end of -year")
+	timezone := fs.String("timezone", env.string("TIMEZONE", "Australia/Sydney"), "timezone for years, dates and output ($TIMEZONE)")
+	category := fs.String("category", "", "only list transactions in this category, or its subcategories, by ID (eg restaurants-and-cafes)")
+	tag := fs.String("tag", "", "only list transactions with this tag")
+	status := fs.String("status", "", "only list held or settled transactions")
+	byCategory := fs.Bool("by-category", false, "total spending by category instead of listing transactions; transfers between the listed accounts, and round-ups, aren't spending")
+	format := fs.String("format", formatText, "output format: text, json or csv")
+	fs.Parse(args)
+
+	if err := env.err(); err != nil {
+		return err
+	}
+	if err := accounts.validate(); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatText, formatJSON, formatCSV); err != nil {
+		return err
+	}
+
+	loc, err := loadLocation(*timezone)
+	if err != nil {
+		return err
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	params := upapi.ListTransactionsParams{
+		Since:    time.Date(*year, time.January, 1, 0, 0, 0, 0, loc),
+		Until:    time.Date(*year+1, time.January, 1, 0, 0, 0, 0, loc),
+		Category: *category,
+		Tag:      *tag,
+		Status:   strings.ToUpper(*status),
+	}
+	if *since != "" {
+		if params.Since, err = parseTime(*since, loc); err != nil {
+			return usageErrorf("invalid -since: %v", err)
+		}
+	}
+	if *until != "" {
+		if params.Until, err = parseTime(*until, loc); err != nil {
+			return usageErrorf("invalid -until: %v", err)
+		}
+	}
+	if params.Status != "" && params.Status != upapi.TransactionStatusHeld && params.Status != upapi.TransactionStatusSettled {
+		return usageErrorf("unknown -status %q, expected held or settled", *status)
+	}
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rows := []transactionRow{}
+	ledgers := make(map[string]*ledger.Ledger)
+	for acc, err := range client.Accounts(ctx, upapi.ListAccountsParams{}) {
+		if err != nil {
+			return err
+		}
+		if !accounts.keep(acc) {
+			continue
+		}
+
+		var xacts []upapi.Transaction
+		for x, err := range client.TransactionsForAccount(ctx, acc.ID, params) {
+			if err != nil {
+				return err
+			}
+			xacts = append(xacts, x)
+
+			row := transactionRow{
+				ID:          x.ID,
+				Account:     acc.Attributes.DisplayName,
+				Status:      x.Attributes.Status,
+				CreatedAt:   x.Attributes.CreatedAt.In(loc),
+				Description: x.Attributes.Description,
+				Amount:      ledger.Money(x.Attributes.Amount.ValueInBaseUnits),
+				CategoryID:  x.CategoryID(),
+				Tags:        ledger.Tags(x.TagIDs()),
+			}
+			if x.Attributes.SettledAt != nil {
+				settledAt := x.Attributes.SettledAt.In(loc)
+				row.SettledAt = &settledAt
+			}
+			rows = append(rows, row)
+		}
+
+		if *byCategory {
+			ledgers[acc.ID] = ledger.FromTransactions(acc.Attributes.DisplayName, xacts, ledger.WithLocation(loc))
+		}
+	}
+
+	if *byCategory {
+		return printSpend(ctx, client, *format, ledgers, params.Since, params.Until)
+	}
+
+	// Each account's transactions come back newest first, but the accounts need interleaving
+	slices.SortStableFunc(rows, func(i, j transactionRow) int { return j.CreatedAt.Compare(i.CreatedAt) })
+
+	return printRows(*format, rows, "DATE\tACCOUNT\tDESCRIPTION\tAMOUNT\tSTATUS\tCATEGORY\tTAGS\tID", func(r transactionRow) string {
+		return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s", r.CreatedAt.Format(time.DateTime), r.Account, r.Description, fbar.PrettyMoney(int(r.Amount)), r.Status, r.CategoryID, strings.Join(r.Tags, ", "), r.ID)
+	})
+}
+
+type categoryRow struct {
+	CategoryID string       `json:"category_id" csv:"category_id"`
+	Category   string       `json:"category" csv:"category"`
+	Parent     string       `json:"parent" csv:"parent"`
+	Spend      ledger.Money `json:"spend" csv:"spend"`
+}
+
+// printSpend prints the total spent in each category between start and end, across every ledger
+func printSpend(ctx context.Context, client *upapi.Client, format string, ledgers map[string]*ledger.Ledger, start, end time.Time) error {
+	// Transfers have to be labelled before they can be left out of the spending
+	ledger.MatchTransfers(ledgers, start, end)
+
+	spend := make(map[string]int)
+	for _, l := range ledgers {
+		for id, amount := range l.SpendByCategory(start, end) {
+			spend[id] += amount
+		}
+	}
+
+	trees, err := client.CategoryTree(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := []categoryRow{}
+	var walk func(t *upapi.CategoryTree, parent string)
+	walk = func(t *upapi.CategoryTree, parent string) {
+		if amount, ok := spend[t.Category.ID]; ok {
+			rows = append(rows, categoryRow{CategoryID: t.Category.ID, Category: t.Category.Attributes.Name, Parent: parent, Spend: ledger.Money(amount)})
+			delete(spend, t.Category.ID)
+		}
+		for _, child := range t.Children {
+			walk(child, t.Category.Attributes.Name)
+		}
+	}
+	for _, t := range trees {
+		walk(t, "")
+	}
+
+	// Whatever's left is uncategorised, or in a category Up no longer lists
+	for _, id := range slices.Sorted(maps.Keys(spend)) {
+		name := id
+		if id == "" {
+			name = "Uncategorised"
+		}
+		rows = append(rows, categoryRow{CategoryID: id, Category: name, Spend: ledger.Money(spend[id])})
+	}
+
+	return printRows(format, rows, "CATEGORY\tPARENT\tSPEND", func(r categoryRow) string {
+		return fmt.Sprintf("%s\t%s\t%s", r.Category, r.Parent, fbar.PrettyMoney(int(r.Spend)))
+	})
+}
+
+// parseTime parses a date, taken as midnight in loc, or an RFC 3339 time
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, loc); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// printRows prints rows in the given format. Text output is a table with the given header, and a line per row from line
+func printRows[T any](format string, rows []T, header string, line func(T) string) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+
+	case formatCSV:
+		if err := gocsv.Marshal(rows, os.Stdout); err != nil {
+			return fmt.Errorf("failed to write CSV: %w", err)
+		}
+
+	default:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, header)
+		for _, r := range rows {
+			fmt.Fprintln(tw, line(r))
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("failed to write table: %w", err)
+		}
+	}
+
+	return nil
+}
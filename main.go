@@ -1,33 +1,135 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
-	"strconv"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	_ "time/tzdata" // so that -timezone works on machines without a timezone database
 
-	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
 )
 
+// Exit codes. Usage errors use 2, the same as the flag package does for flags it can't parse
+const (
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"report", "print the FBAR report for a year, and write CSVs of each account (the default)", runReport},
+	{"export", "write the FBAR report as CSVs and a FinCEN 114 batch XML file, ready to file", runExport},
+	{"accounts", "list accounts", runAccounts},
+	{"transactions", "list transactions", runTransactions},
+	{"tag", "tag transactions as reviewed or internal transfers, or remove tags", runTag},
+	{"check", "check that UP_TOKEN works", runCheck},
+	{"serve", "keep ledgers up to date and serve the year to date figures over HTTP", runServe},
+}
+
 func main() {
-	tok := os.Getenv("UP_TOKEN")
-	if tok == "" {
-		panic("UP_TOKEN environment variable not set")
+	args := os.Args[1:]
+	if len(args) == 1 && slices.Contains([]string{"help", "-h", "-help", "--help"}, args[0]) {
+		usage(os.Stdout)
+		return
+	}
+
+	// Running with no subcommand, or just flags, is a report, as it was before there were subcommands
+	name := "report"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	year := os.Getenv("YEAR")
-	if year == "" {
-		panic("YEAR environment variable not set")
+	idx := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if idx == -1 {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+
+	if err := commands[idx].run(args); err != nil {
+		fatal(err)
+	}
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+func usage(w *os.File) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\n", programName())
+	fmt.Fprintln(w, "Works out the maximum values of your Up accounts for the FBAR. Needs an Up API token in UP_TOKEN.")
+	fmt.Fprintln(w, "\nCommands:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nRun '%s <command> -help' for a command's flags.\n", programName())
+}
+
+// exitError is an error that should end the program with a particular exit code
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+func exitUsageError(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	fmt.Fprintf(os.Stderr, "Run '%s -help' for usage.\n", programName())
+	os.Exit(exitUsage)
+}
+
+// fatal prints err, with some guidance for the Up API failures people are most likely to hit, and exits
+func fatal(err error) {
+	var uErr usageError
+	if errors.As(err, &uErr) {
+		exitUsageError(err)
+	}
+
+	fmt.Fprintln(os.Stderr, "Error:", err)
+
+	switch {
+	case upapi.IsUnauthorized(err):
+		fmt.Fprintln(os.Stderr, "\nUp didn't accept your UP_TOKEN. It may have been revoked, or copied incorrectly. You can generate a new one at https://api.up.com.au/getting_started")
+	case upapi.IsRateLimited(err):
+		fmt.Fprintln(os.Stderr, "\nUp is rate limiting requests, even after retrying. Wait a few minutes and try again, or lower -parallelism.")
+	case upapi.IsNotFound(err):
+		fmt.Fprintln(os.Stderr, "\nUp couldn't find something it was asked for. If an account was closed while the program was running, try again.")
 	}
 
-	intYear, err := strconv.Atoi(year)
-	if err != nil {
-		panic(err)
+	var apiErr *upapi.APIError
+	if errors.As(err, &apiErr) && len(apiErr.RequestIDs) > 0 {
+		fmt.Fprintln(os.Stderr, "\nIf you need to ask Up about this, quote these request IDs:")
+		for _, h := range slices.Sorted(maps.Keys(apiErr.RequestIDs)) {
+			fmt.Fprintf(os.Stderr, "\t%s: %s\n", h, apiErr.RequestIDs[h])
+		}
 	}
 
-	r, err := fbar.GenerateReport(tok, intYear)
-	if err != nil {
-		panic(err)
+	code := exitFailed
+	var eErr exitError
+	if errors.As(err, &eErr) {
+		code = eErr.code
 	}
 
-	fmt.Println(r.PrettyString())
+	os.Exit(code)
 }
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/gocarina/gocsv"
+
+	"github.com/moskyb/upbank-fbar-calculator/cassette"
+	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/form8938"
+	"github.com/moskyb/upbank-fbar-calculator/ledger"
+	"github.com/moskyb/upbank-fbar-calculator/store"
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// reportFlags are the flags for generating a report, shared by report and export. Each defaults to the environment
+// variable that configured it before there were flags
+type reportFlags struct {
+	commonFlags
+	accountFlags
+	env envDefaults
+
+	year          int
+	timezone      string
+	outputDir     string
+	exchangeRates string
+	filerProfile  string
+
+	strict          bool
+	anchoring       string
+	excludeHeld     bool
+	settlementDates bool
+	parallelism     int
+	failFast        bool
+
+	filingStatus    string
+	livingAbroad    bool
+	jointWithSpouse bool
+
+	record       string
+	replay       string
+	cacheDir     string
+	rebuildCache bool
+}
+
+func (f *reportFlags) register(fs *flag.FlagSet) {
+	f.commonFlags.register(fs)
+	f.accountFlags.register(fs)
+
+	fs.IntVar(&f.year, "year", f.env.int("YEAR", time.Now().Year()-1), "calendar year to report on ($YEAR, default last year)")
+	fs.StringVar(&f.timezone, "timezone", f.env.string("TIMEZONE", ""), "timezone the year starts and ends in, eg America/New_York ($TIMEZONE, default Australia/Sydney)")
+	fs.StringVar(&f.outputDir, "output", f.env.string("OUTPUT_DIR", "."), "directory to write files to ($OUTPUT_DIR)")
+	fs.StringVar(&f.exchangeRates, "exchange-rates", f.env.string("EXCHANGE_RATES", ""), "Treasury Reporting Rates of Exchange CSV to use instead of the bundled one ($EXCHANGE_RATES)")
+	fs.StringVar(&f.filerProfile, "filer-profile", f.env.string("FILER_PROFILE", ""), "JSON filer profile; if given, a FinCEN 114 batch XML file is written too ($FILER_PROFILE)")
+
+	fs.BoolVar(&f.strict, "strict", f.env.bool("STRICT_RECONCILIATION"), "fail if a reconstructed balance doesn't match Up's ($STRICT_RECONCILIATION)")
+	fs.StringVar(&f.anchoring, "anchoring", f.env.string("ANCHORING", string(fbar.AnchorForward)), "reconstruct balances forward from zero, or backward from today's balance ($ANCHORING)")
+	fs.BoolVar(&f.excludeHeld, "exclude-held", f.env.bool("EXCLUDE_HELD"), "leave pending transactions out of balances ($EXCLUDE_HELD)")
+	fs.BoolVar(&f.settlementDates, "settlement-dates", f.env.bool("SETTLEMENT_DATES"), "count transactions in the year they settled rather than were made ($SETTLEMENT_DATES)")
+	fs.IntVar(&f.parallelism, "parallelism", f.env.int("PARALLELISM", fbar.DefaultParallelism), "how many accounts to fetch at once ($PARALLELISM)")
+	fs.BoolVar(&f.failFast, "fail-fast", f.env.bool("FAIL_FAST"), "stop at the first account that fails ($FAIL_FAST)")
+
+	fs.StringVar(&f.filingStatus, "filing-status", f.env.string("FILING_STATUS", ""), "also evaluate Form 8938: single, head_of_household, married_filing_jointly or married_filing_separately ($FILING_STATUS)")
+	fs.BoolVar(&f.livingAbroad, "living-abroad", f.env.bool("LIVING_ABROAD"), "use the Form 8938 thresholds for living abroad ($LIVING_ABROAD)")
+	fs.BoolVar(&f.jointWithSpouse, "joint-with-spouse", f.env.bool("JOINT_WITH_SPOUSE"), "joint Up accounts are held with your spouse, for Form 8938 ($JOINT_WITH_SPOUSE)")
+
+	fs.StringVar(&f.record, "record", "", "record every request made to Up to this cassette file")
+	fs.StringVar(&f.replay, "replay", "", "replay requests from this cassette file instead of talking to Up; UP_TOKEN isn't needed")
+	fs.StringVar(&f.cacheDir, "cache", "", "keep transactions in this directory, and only fetch new ones on later runs")
+	fs.BoolVar(&f.rebuildCache, "rebuild-cache", false, "throw away everything in the -cache directory and fetch it all again")
+}
+
+func (f *reportFlags) validate() error {
+	if err := f.env.err(); err != nil {
+		return err
+	}
+
+	if err := f.accountFlags.validate(); err != nil {
+		return err
+	}
+
+	if f.record != "" && f.replay != "" {
+		return usageErrorf("-record and -replay can't be used together")
+	}
+
+	if f.rebuildCache && f.cacheDir == "" {
+		return usageErrorf("-rebuild-cache needs a -cache directory")
+	}
+
+	if a := fbar.Anchoring(f.anchoring); a != fbar.AnchorForward && a != fbar.AnchorBackward {
+		return usageErrorf("unknown -anchoring %q, expected forward or backward", f.anchoring)
+	}
+
+	if f.parallelism < 1 {
+		return usageErrorf("-parallelism must be at least 1")
+	}
+
+	return nil
+}
+
+// generate builds the report the flags describe. Unless -fail-fast is set, a report is returned even if some accounts
+// failed, along with their errors, and its CSVs are still written
+func (f *reportFlags) generate(ctx context.Context) (*fbar.Report, error) {
+	opts := []fbar.ReportOption{
+		fbar.WithAnchoring(fbar.Anchoring(f.anchoring)),
+		fbar.WithParallelism(f.parallelism),
+		fbar.WithOutputDir(f.outputDir),
+		fbar.WithAccountFilter(f.keep),
+	}
+
+	if f.exchangeRates != "" {
+		rates, err := fbar.LoadExchangeRatesFile(f.exchangeRates)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, fbar.WithExchangeRates(rates))
+	}
+
+	loc, err := loadLocation(f.timezone)
+	if err != nil {
+		return nil, err
+	}
+	if loc != nil {
+		opts = append(opts, fbar.WithLocation(loc))
+	}
+
+	if f.strict {
+		opts = append(opts, fbar.WithStrictReconciliation())
+	}
+
+	if f.excludeHeld {
+		opts = append(opts, fbar.WithLedgerOptions(ledger.WithExcludeHeld()))
+	}
+
+	if f.settlementDates {
+		opts = append(opts, fbar.WithLedgerOptions(ledger.WithSettlementDates()))
+	}
+
+	if f.failFast {
+		opts = append(opts, fbar.WithFailFast())
+	}
+
+	if f.cacheDir != "" {
+		s, err := store.Open(f.cacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open cache: %w", err)
+		}
+
+		if f.rebuildCache {
+			if err := s.InvalidateAll(); err != nil {
+				return nil, fmt.Errorf("failed to clear cache: %w", err)
+			}
+		}
+
+		opts = append(opts, fbar.WithStore(s))
+	}
+
+	if err := os.MkdirAll(f.outputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	var client *upapi.Client
+	var rec *cassette.Recorder
+	switch {
+	case f.replay != "":
+		rep, err := cassette.Load(f.replay)
+		if err != nil {
+			return nil, err
+		}
+		client = upapi.NewClient(os.Getenv("UP_TOKEN"), upapi.WithLogger(f.logger()), upapi.WithHTTPClient(rep.Client()))
+
+	case f.record != "":
+		rec = cassette.NewRecorder(f.record, nil)
+		client, err = f.client(upapi.WithHTTPClient(rec.Client()))
+
+	default:
+		client, err = f.client()
+	}
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, fbar.WithClient(client))
+
+	r, err := fbar.GenerateReport(ctx, "", f.year, opts...)
+	if rec != nil {
+		// Save whatever was recorded, even if the report failed, since the failure is worth having a record of too
+		if saveErr := rec.Save(); saveErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to save cassette: %w", saveErr))
+		}
+	}
+	if err != nil && (r == nil || f.failFast) {
+		return nil, err
+	}
+
+	if dumpErr := r.DumpCSV(); dumpErr != nil {
+		return nil, dumpErr
+	}
+
+	return r, err
+}
+
+// form8938 evaluates Form 8938 if a filing status was given, or returns nil if not
+func (f *reportFlags) form8938(r *fbar.Report) (*form8938.Summary, error) {
+	if f.filingStatus == "" {
+		return nil, nil
+	}
+
+	opts := form8938.Options{
+		FilingStatus:            form8938.FilingStatus(f.filingStatus),
+		LivingAbroad:            f.livingAbroad,
+		JointAccountsWithSpouse: f.jointWithSpouse,
+	}
+
+	// The filer profile has the account numbers that Part V line 2 asks for
+	if f.filerProfile != "" {
+		profile, err := fbar.LoadFilerProfileFile(f.filerProfile)
+		if err != nil {
+			return nil, err
+		}
+		opts.AccountNumbers = profile.AccountNumbers
+	}
+
+	return form8938.FromReport(r, opts)
+}
+
+// writeXML writes the FinCEN 114 batch XML file if a filer profile was given, and returns its path
+func (f *reportFlags) writeXML(r *fbar.Report) (string, error) {
+	if f.filerProfile == "" {
+		return "", nil
+	}
+
+	profile, err := fbar.LoadFilerProfileFile(f.filerProfile)
+	if err != nil {
+		return "", err
+	}
+
+	if err := profile.Validate(r); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(f.outputDir, fmt.Sprintf("fbar-%d.xml", r.FinancialYear))
+	out, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create XML file: %w", err)
+	}
+	defer out.Close()
+
+	if err := r.WriteFBARXML(out, *profile); err != nil {
+		return "", err
+	}
+
+	return path, out.Close()
+}
+
+// reportJSON is the report as printed by -format json
+type reportJSON struct {
+	Year         int                  `json:"year"`
+	Timezone     string               `json:"timezone"`
+	ExchangeRate string               `json:"exchange_rate"`
+	Accounts     []fbar.AccountRecord `json:"accounts"`
+	Threshold    fbar.ThresholdResult `json:"threshold"`
+
+	HouseholdHighWaterMark    ledger.Money `json:"household_high_water_mark"`
+	HouseholdHighWaterMarkUSD int          `json:"household_high_water_mark_usd"`
+
+	Warnings []string          `json:"warnings"`
+	Form8938 *form8938.Summary `json:"form_8938,omitempty"`
+}
+
+func runReport(args []string) error {
+	fs := newFlagSet("report", "[flags]", "Prints the FBAR report for a year, and writes a CSV of each account's transactions plus a summary CSV\n(fbar-<year>.csv) to the output directory. If some accounts fail, the report on the rest is still printed and\nwritten, but the program exits with an error.")
+	var f reportFlags
+	f.register(fs)
+	format := fs.String("format", formatText, "how to print the report: text, json or csv")
+	fs.Parse(args)
+
+	if err := f.validate(); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatText, formatJSON, formatCSV); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return f.report(ctx, os.Stdout, *format)
+}
+
+// report generates the report and prints it to w in the given format
+func (f *reportFlags) report(ctx context.Context, w io.Writer, format string) error {
+	r, accountErr := f.generate(ctx)
+	if r == nil {
+		return accountErr
+	}
+
+	summary, err := f.form8938(r)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case formatText:
+		fmt.Fprintln(w, r.PrettyString())
+		if summary != nil {
+			fmt.Fprintln(w, summary.PrettyString())
+		}
+
+	case formatJSON:
+		out := reportJSON{
+			Year:                      r.FinancialYear,
+			Timezone:                  r.Location.String(),
+			ExchangeRate:              r.ExchangeRate.String(),
+			Accounts:                  r.Records(),
+			Threshold:                 r.Threshold,
+			HouseholdHighWaterMark:    ledger.Money(r.Household.HighWaterMark),
+			HouseholdHighWaterMarkUSD: r.Household.HighWaterMarkUSD,
+			Warnings:                  []string{},
+			Form8938:                  summary,
+		}
+		for _, w := range r.Warnings {
+			out.Warnings = append(out.Warnings, w.Error())
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+
+	case formatCSV:
+		if err := gocsv.Marshal(r.Records(), w); err != nil {
+			return fmt.Errorf("failed to write CSV: %w", err)
+		}
+	}
+
+	if accountErr != nil {
+		// A FinCEN 114 missing accounts shouldn't be filed, so don't write one
+		return fmt.Errorf("the report leaves out accounts that couldn't be processed: %w", accountErr)
+	}
+
+	if _, err := f.writeXML(r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export", "-filer-profile <file> [flags]", "Writes the FBAR report for a year as a FinCEN 114 batch XML file (fbar-<year>.xml) ready to upload to BSA\nE-Filing, along with the same CSVs as report.")
+	var f reportFlags
+	f.register(fs)
+	fs.Parse(args)
+
+	if err := f.validate(); err != nil {
+		return err
+	}
+	if f.filerProfile == "" {
+		return usageErrorf("export needs a -filer-profile")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r, err := f.generate(ctx)
+	if err != nil {
+		return err
+	}
+
+	path, err := f.writeXML(r)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range r.Warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", w)
+	}
+	fmt.Println("Wrote", path)
+
+	return nil
+}
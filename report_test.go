@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapitest"
+)
+
+func TestReportPartialFailure(t *testing.T) {
+	t.Setenv("UP_TOKEN", upapitest.Token)
+	dir := t.TempDir()
+
+	at := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	srv := upapitest.NewServer(upapitest.Fixtures{})
+	defer srv.Close()
+
+	srv.AddAccount(upapitest.Account("spending", "Spending", 100_00, at.AddDate(-1, 0, 0)),
+		upapitest.Transaction("1", "Salary", 100_00, at),
+	)
+	srv.AddAccount(upapitest.Account("saver", "Saver", 0, at.AddDate(-1, 0, 0)))
+	srv.FailNextOn("/accounts/saver/transactions", http.StatusForbidden)
+
+	var f reportFlags
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	f.register(fs)
+	if err := fs.Parse([]string{"-q", "-year", "2023", "-output", dir}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.host = srv.URL
+
+	var out strings.Builder
+	err := f.report(context.Background(), &out, formatText)
+	if err == nil || !strings.Contains(err.Error(), "saver") {
+		t.Errorf("expected an error about the saver account, got %v", err)
+	}
+
+	if !strings.Contains(out.String(), "Account: Spending") {
+		t.Errorf("expected the spending account to still be reported on, got %q", out.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "fbar-2023.csv")); err != nil {
+		t.Errorf("expected the summary CSV to be written, got %v", err)
+	}
+}
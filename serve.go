@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/daemon"
+	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/ledger"
+	"github.com/moskyb/upbank-fbar-calculator/store"
+)
+
+// runServe runs the daemon until interrupted, keeping every account's ledger live and serving the year to date figures
+func runServe(args []string) error {
+	fs := newFlagSet("serve", "[flags]", "Keeps every account's transactions up to date in a local cache, and serves each account's year to date\nhigh water mark as JSON at /summary, /accounts and /accounts/<id>.")
+	var common commonFlags
+	common.register(fs)
+	var env envDefaults
+	listen := fs.String("listen", "127.0.0.1:8080", "address to serve the JSON API (and webhook receiver) on")
+	cacheDir := fs.String("cache", "up-cache", "directory to keep transactions in")
+	poll := fs.Duration("poll", daemon.DefaultPollInterval, "how often to sync every account, or 0 to rely on webhooks alone")
+	webhookURL := fs.String("webhook-url", "", "public URL that reaches -listen's /webhook; a webhook pointing at it is registered with Up while the daemon runs")
+	timezone := fs.String("timezone", env.string("TIMEZONE", ""), "timezone the year starts and ends in ($TIMEZONE, default Australia/Sydney)")
+	exchangeRates := fs.String("exchange-rates", env.string("EXCHANGE_RATES", ""), "Treasury Reporting Rates of Exchange CSV to use instead of the bundled one ($EXCHANGE_RATES)")
+	excludeHeld := fs.Bool("exclude-held", env.bool("EXCLUDE_HELD"), "leave pending transactions out of balances ($EXCLUDE_HELD)")
+	settlementDates := fs.Bool("settlement-dates", env.bool("SETTLEMENT_DATES"), "count transactions in the year they settled rather than were made ($SETTLEMENT_DATES)")
+	fs.Parse(args)
+
+	if err := env.err(); err != nil {
+		return err
+	}
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+
+	s, err := store.Open(*cacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to open cache: %w", err)
+	}
+
+	opts := []daemon.Option{daemon.WithPollInterval(*poll), daemon.WithLogger(client.Logger)}
+
+	if *exchangeRates != "" {
+		rates, err := fbar.LoadExchangeRatesFile(*exchangeRates)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, daemon.WithExchangeRates(rates))
+	}
+
+	loc, err := loadLocation(*timezone)
+	if err != nil {
+		return err
+	}
+	if loc != nil {
+		opts = append(opts, daemon.WithLocation(loc))
+	}
+
+	if *excludeHeld {
+		opts = append(opts, daemon.WithLedgerOptions(ledger.WithExcludeHeld()))
+	}
+
+	if *settlementDates {
+		opts = append(opts, daemon.WithLedgerOptions(ledger.WithSettlementDates()))
+	}
+
+	// Bind before anything else, so that an address that's already in use fails before a webhook is registered
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+	}
+	defer ln.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *webhookURL != "" {
+		hook, err := client.CreateWebhook(ctx, *webhookURL, "upbank-fbar-calculator serve")
+		if err != nil {
+			return fmt.Errorf("failed to register webhook: %w", err)
+		}
+		defer func() {
+			// ctx is cancelled by now, but the webhook should still be cleaned up
+			if err := client.DeleteWebhook(context.Background(), hook.Data.ID); err != nil {
+				client.Logger.Error("failed to delete webhook", "id", hook.Data.ID, "error", err)
+			}
+		}()
+
+		opts = append(opts, daemon.WithWebhookSecret(hook.Data.Attributes.SecretKey))
+	}
+
+	d, err := daemon.New(client, s, opts...)
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{Handler: d.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if err := httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+			stop()
+		}
+	}()
+	fmt.Printf("Serving on http://%s\n", ln.Addr())
+
+	runErr := d.Run(ctx)
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	default:
+		return runErr
+	}
+}
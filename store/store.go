@@ -0,0 +1,250 @@
+// Package store keeps a local copy of each account's transactions, so that runs after the first only need to fetch
+// what's changed since
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+const (
+	transactionsExt = ".jsonl"
+	stateExt        = ".state.json"
+)
+
+// Store persists transactions as a JSON lines file per account, alongside a small state file recording how far the
+// account has been synced
+type Store struct {
+	dir string
+}
+
+// State is what the store knows about an account's sync progress
+type State struct {
+	// NewestSettled is the creation time of the newest settled transaction in the store
+	NewestSettled *time.Time `json:"newest_settled,omitempty"`
+	// OldestHeld is the creation time of the oldest transaction still held when the account was last synced. Held
+	// transactions can change amount or disappear entirely before settling, so they're always fetched again
+	OldestHeld *time.Time `json:"oldest_held,omitempty"`
+	LastSynced time.Time  `json:"last_synced"`
+}
+
+// Open returns a store backed by dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// Sync brings the stored transactions for an account up to date and returns all of them, newest first. The first sync
+// fetches the account's entire history; after that, only transactions created since the newest settled transaction (or
+// the oldest held one, if that's earlier) are fetched
+func (s *Store) Sync(ctx context.Context, client *upapi.Client, accountID string) ([]upapi.Transaction, error) {
+	state, err := s.State(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.Transactions(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := upapi.ListTransactionsParams{}
+	switch {
+	case state.NewestSettled == nil:
+		// Never synced, or nothing has settled yet, so fetch everything
+		stored = nil
+	case state.OldestHeld != nil && state.OldestHeld.Before(*state.NewestSettled):
+		params.Since = *state.OldestHeld
+	default:
+		params.Since = *state.NewestSettled
+	}
+
+	// Anything in the window being refetched is replaced by what Up says now, which drops held transactions that
+	// have since been cancelled
+	stored = slices.DeleteFunc(stored, func(x upapi.Transaction) bool {
+		return !params.Since.IsZero() && !x.Attributes.CreatedAt.Before(params.Since)
+	})
+
+	fetched, err := client.PaginateAllTransactionsForAccount(ctx, accountID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transactions: %w", err)
+	}
+
+	byID := make(map[string]upapi.Transaction, len(stored)+len(fetched))
+	for _, x := range stored {
+		byID[x.ID] = x
+	}
+	for _, x := range fetched {
+		byID[x.ID] = x
+	}
+
+	all := make([]upapi.Transaction, 0, len(byID))
+	for _, x := range byID {
+		all = append(all, x)
+	}
+	slices.SortStableFunc(all, func(i, j upapi.Transaction) int {
+		if c := j.Attributes.CreatedAt.Compare(i.Attributes.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(j.ID, i.ID)
+	})
+
+	if err := s.write(accountID, all, stateOf(all)); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+// Transactions returns the stored transactions for an account, newest first, without syncing
+func (s *Store) Transactions(accountID string) ([]upapi.Transaction, error) {
+	f, err := os.Open(s.path(accountID, transactionsExt))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transactions file: %w", err)
+	}
+	defer f.Close()
+
+	var xacts []upapi.Transaction
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var x upapi.Transaction
+		if err := json.Unmarshal(scanner.Bytes(), &x); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stored transaction: %w", err)
+		}
+		xacts = append(xacts, x)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transactions file: %w", err)
+	}
+
+	return xacts, nil
+}
+
+// State returns the sync state for an account. Accounts that have never been synced have a zero State
+func (s *Store) State(accountID string) (State, error) {
+	body, err := os.ReadFile(s.path(accountID, stateExt))
+	if errors.Is(err, fs.ErrNotExist) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(body, &state); err != nil {
+		return State{}, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+
+	return state, nil
+}
+
+// Invalidate removes everything stored for an account, so that the next sync fetches its entire history again
+func (s *Store) Invalidate(accountID string) error {
+	for _, ext := range []string{transactionsExt, stateExt} {
+		if err := os.Remove(s.path(accountID, ext)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", ext, err)
+		}
+	}
+
+	return nil
+}
+
+// InvalidateAll removes everything stored for every account
+func (s *Store) InvalidateAll() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read store directory: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, transactionsExt) && !strings.HasSuffix(name, stateExt) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) path(accountID, ext string) string {
+	return filepath.Join(s.dir, accountID+ext)
+}
+
+func stateOf(xacts []upapi.Transaction) State {
+	state := State{LastSynced: time.Now()}
+	for _, x := range xacts {
+		createdAt := x.Attributes.CreatedAt
+
+		if x.Attributes.Status == upapi.TransactionStatusHeld {
+			if state.OldestHeld == nil || createdAt.Before(*state.OldestHeld) {
+				state.OldestHeld = &createdAt
+			}
+			continue
+		}
+
+		if state.NewestSettled == nil || createdAt.After(*state.NewestSettled) {
+			state.NewestSettled = &createdAt
+		}
+	}
+
+	return state
+}
+
+// write replaces the stored transactions and state for an account. Each file is written to a temporary file and renamed
+// into place, so an interrupted write never leaves a half written file behind
+func (s *Store) write(accountID string, xacts []upapi.Transaction, state State) error {
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	for _, x := range xacts {
+		if err := enc.Encode(x); err != nil {
+			return fmt.Errorf("failed to marshal transaction: %w", err)
+		}
+	}
+
+	if err := writeAtomic(s.path(accountID, transactionsExt), []byte(sb.String())); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	return writeAtomic(s.path(accountID, stateExt), body)
+}
+
+func writeAtomic(path string, body []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename %s into place: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+	"github.com/moskyb/upbank-fbar-calculator/upapitest"
+)
+
+func TestSync(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2023, time.January, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	held := upapitest.Transaction("held", "Coffee", -500, day(3))
+	held.Attributes.Status = "HELD"
+
+	fixtures := upapitest.Fixtures{
+		Accounts: []upapi.Account{upapitest.Account("spending", "Spending", 0, day(1))},
+		Transactions: map[string][]upapi.Transaction{
+			"spending": {
+				upapitest.Transaction("1", "Salary", 1000, day(1)),
+				upapitest.Transaction("2", "Groceries", -100, day(2)),
+				held,
+			},
+		},
+	}
+	srv := upapitest.NewServer(fixtures)
+	defer srv.Close()
+
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	xacts, err := s.Sync(context.Background(), srv.Client(), "spending")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(xacts) != 3 {
+		t.Fatalf("expected 3 transactions after the first sync, got %d", len(xacts))
+	}
+
+	state, err := s.State("spending")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !state.NewestSettled.Equal(day(2)) || !state.OldestHeld.Equal(day(3)) {
+		t.Errorf("unexpected state after first sync: %+v", state)
+	}
+
+	// The held transaction is cancelled, and a new one comes in. Only the new data should be fetched, so the server
+	// forgetting the old transactions shouldn't matter
+	fixtures.Transactions["spending"] = []upapi.Transaction{
+		upapitest.Transaction("2", "Groceries", -100, day(2)),
+		upapitest.Transaction("4", "Rent", -300, day(4)),
+	}
+	srv.Close()
+	srv = upapitest.NewServer(fixtures)
+	defer srv.Close()
+
+	xacts, err = s.Sync(context.Background(), srv.Client(), "spending")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ids []string
+	for _, x := range xacts {
+		ids = append(ids, x.ID)
+	}
+
+	if len(ids) != 3 || ids[0] != "4" || ids[1] != "2" || ids[2] != "1" {
+		t.Errorf("expected transactions 4, 2 and 1 after the second sync, got %v", ids)
+	}
+
+	if err := s.Invalidate("spending"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if xacts, _ := s.Transactions("spending"); len(xacts) != 0 {
+		t.Errorf("expected no transactions after invalidating, got %d", len(xacts))
+	}
+}
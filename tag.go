@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/moskyb/upbank-fbar-calculator/fbar"
+	"github.com/moskyb/upbank-fbar-calculator/ledger"
+)
+
+// runTag adds a tag to transactions, or removes it, so that transfers can be annotated for the report without the Up app
+func runTag(args []string) error {
+	fs := newFlagSet("tag", "[flags] <tag> <transaction ID>...", fmt.Sprintf(
+		"Tags transactions, by the IDs listed by 'transactions'. Tag a transaction %s to match it like any other\ntransfer between your accounts, or %s to stop the report warning about a transfer you've checked by hand.",
+		ledger.TagInternalTransfer, fbar.TagReviewed))
+	var common commonFlags
+	common.register(fs)
+	remove := fs.Bool("remove", false, "remove the tag instead of adding it")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		return usageErrorf("expected a tag and at least one transaction ID")
+	}
+	tag, ids := fs.Arg(0), fs.Args()[1:]
+
+	client, err := common.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, id := range ids {
+		if *remove {
+			if err := client.RemoveTagsFromTransaction(ctx, id, tag); err != nil {
+				return err
+			}
+			fmt.Printf("Removed %s from %s\n", tag, id)
+			continue
+		}
+
+		if err := client.AddTagsToTransaction(ctx, id, tag); err != nil {
+			return err
+		}
+		fmt.Printf("Tagged %s with %s\n", id, tag)
+	}
+
+	return nil
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"iter"
 	"net/http"
-	"net/url"
 	"time"
 
 	"github.com/moskyb/upbank-fbar-calculator/qparam"
@@ -31,31 +31,13 @@ type ListAccountsParams struct {
 	After  string `qparam:"page[after]"`
 }
 
-func (c *Client) PaginateAllAccounts(ctx context.Context, params ListAccountsParams) ([]Account, error) {
-	var accounts []Account
-
-	for {
-		resp, err := c.ListAccounts(ctx, params)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list accounts: %w", err)
-		}
-
-		accounts = append(accounts, resp.Data...)
-
-		if resp.Links.Next == nil {
-			break
-		}
-
-		nextURL, err := url.Parse(*resp.Links.Next)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse next URL: %w", err)
-		}
-
-		after := nextURL.Query().Get("page[after]")
-		params.After = after
-	}
+// Accounts iterates over every account matching params, fetching pages as they're needed
+func (c *Client) Accounts(ctx context.Context, params ListAccountsParams) iter.Seq2[Account, error] {
+	return paginate(ctx, "accounts", params, func(p *ListAccountsParams, after string) { p.After = after }, c.ListAccounts)
+}
 
-	return accounts, nil
+func (c *Client) PaginateAllAccounts(ctx context.Context, params ListAccountsParams) ([]Account, error) {
+	return collect(c.Accounts(ctx, params))
 }
 
 func (c *Client) ListAccounts(ctx context.Context, params ListAccountsParams) (*Response[[]Account], error) {
@@ -0,0 +1,182 @@
+package upapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/moskyb/upbank-fbar-calculator/qparam"
+)
+
+type Category struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Attributes struct {
+		Name string `json:"name"`
+	} `json:"attributes"`
+	Relationships struct {
+		Parent   Relationship[*ResourceIdentifier]  `json:"parent"`
+		Children Relationship[[]ResourceIdentifier] `json:"children"`
+	} `json:"relationships"`
+	Links *ResourceLinks `json:"links,omitempty"`
+}
+
+// ParentID is the ID of the category's parent, or empty if it's a top level category
+func (c Category) ParentID() string {
+	return c.Relationships.Parent.ID()
+}
+
+type ListCategoriesParams struct {
+	// Parent restricts the list to the children of this category
+	Parent string `qparam:"filter[parent]"`
+}
+
+// ListCategories lists categories. Up returns every category in a single page, so there's no pagination
+func (c *Client) ListCategories(ctx context.Context, params ListCategoriesParams) (*Response[[]Category], error) {
+	url, err := c.buildURL("categories")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	query, err := qparam.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode query params: %w", err)
+	}
+
+	req.URL.RawQuery = qparam.Merge(req.URL.Query(), query).Encode()
+
+	body, err := c.makeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	var resp Response[[]Category]
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+func (c *Client) GetCategory(ctx context.Context, id string) (*Response[Category], error) {
+	url, err := c.buildURL(fmt.Sprintf("categories/%s", id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	body, err := c.makeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	var resp Response[Category]
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// CategorizeTransaction sets the category of a transaction. An empty categoryID removes its category. Only
+// transactions with IsCategorizable set can be categorised
+func (c *Client) CategorizeTransaction(ctx context.Context, transactionID, categoryID string) error {
+	url, err := c.buildURL(fmt.Sprintf("transactions/%s/relationships/category", transactionID))
+	if err != nil {
+		return fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	payload := struct {
+		Data *ResourceIdentifier `json:"data"`
+	}{}
+	if categoryID != "" {
+		payload.Data = &ResourceIdentifier{Type: ResourceTypeCategories, ID: categoryID}
+	}
+
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if _, err := c.makeRequest(req); err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+
+	return nil
+}
+
+// CategoryTree is a category and everything beneath it
+type CategoryTree struct {
+	Category Category
+	Children []*CategoryTree
+}
+
+// CategoryTree fetches every category and arranges them into trees, one per top level category
+func (c *Client) CategoryTree(ctx context.Context) ([]*CategoryTree, error) {
+	resp, err := c.ListCategories(ctx, ListCategoriesParams{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+
+	return BuildCategoryTree(resp.Data), nil
+}
+
+// BuildCategoryTree arranges categories into trees using their parent relationships. Categories whose parent isn't
+// in the list are treated as top level. Siblings are sorted by ID
+func BuildCategoryTree(categories []Category) []*CategoryTree {
+	nodes := make(map[string]*CategoryTree, len(categories))
+	for _, cat := range categories {
+		nodes[cat.ID] = &CategoryTree{Category: cat}
+	}
+
+	var roots []*CategoryTree
+	for _, cat := range categories {
+		node := nodes[cat.ID]
+		if parent, ok := nodes[cat.ParentID()]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	byID := func(i, j *CategoryTree) int { return strings.Compare(i.Category.ID, j.Category.ID) }
+	for _, node := range nodes {
+		slices.SortFunc(node.Children, byID)
+	}
+	slices.SortFunc(roots, byID)
+
+	return roots
+}
+
+// Find returns the node for the category with the given ID anywhere in the tree, or nil if it isn't there
+func (t *CategoryTree) Find(id string) *CategoryTree {
+	if t.Category.ID == id {
+		return t
+	}
+
+	for _, child := range t.Children {
+		if found := child.Find(id); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package upapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildCategoryTree(t *testing.T) {
+	var categories []Category
+	body := `[
+		{"type": "categories", "id": "restaurants-and-cafes", "attributes": {"name": "Restaurants & Cafes"}, "relationships": {"parent": {"data": {"type": "categories", "id": "good-life"}}}},
+		{"type": "categories", "id": "good-life", "attributes": {"name": "Good Life"}, "relationships": {"parent": {"data": null}}},
+		{"type": "categories", "id": "booze", "attributes": {"name": "Booze"}, "relationships": {"parent": {"data": {"type": "categories", "id": "good-life"}}}},
+		{"type": "categories", "id": "home", "attributes": {"name": "Home"}, "relationships": {"parent": {"data": null}}}
+	]`
+	if err := json.Unmarshal([]byte(body), &categories); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	roots := BuildCategoryTree(categories)
+	if len(roots) != 2 || roots[0].Category.ID != "good-life" || roots[1].Category.ID != "home" {
+		t.Fatalf("expected roots good-life and home, got %+v", roots)
+	}
+
+	children := roots[0].Children
+	if len(children) != 2 || children[0].Category.ID != "booze" || children[1].Category.ID != "restaurants-and-cafes" {
+		t.Errorf("expected good-life to have children booze and restaurants-and-cafes, got %+v", children)
+	}
+
+	if found := roots[0].Find("restaurants-and-cafes"); found == nil || found.Category.Attributes.Name != "Restaurants & Cafes" {
+		t.Errorf("expected to find restaurants-and-cafes under good-life, got %+v", found)
+	}
+}
+
+func TestCategorizeTransaction(t *testing.T) {
+	var gotMethod, gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotPath, gotBody = r.Method, r.URL.Path, string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := NewClient("token", WithHost(srv.URL), WithQuiet())
+
+	if err := c.CategorizeTransaction(context.Background(), "xact", "booze"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotMethod != http.MethodPatch || gotPath != "/transactions/xact/relationships/category" {
+		t.Errorf("expected PATCH /transactions/xact/relationships/category, got %s %s", gotMethod, gotPath)
+	}
+
+	if want := `{"data":{"type":"categories","id":"booze"}}`; gotBody != want {
+		t.Errorf("expected body %s, got %s", want, gotBody)
+	}
+
+	if err := c.CategorizeTransaction(context.Background(), "xact", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := `{"data":null}`; gotBody != want {
+		t.Errorf("expected body %s, got %s", want, gotBody)
+	}
+}
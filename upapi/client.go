@@ -1,7 +1,6 @@
 package upapi
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/lmittmann/tint"
 	"github.com/mattn/go-isatty"
@@ -17,11 +17,12 @@ import (
 const DefaultHost = "https://api.up.com.au/api/v1"
 
 type Client struct {
-	Token      string
-	PageSize   int
-	Host       string
-	HTTPClient *http.Client
-	Logger     *slog.Logger
+	Token       string
+	PageSize    int
+	Host        string
+	HTTPClient  *http.Client
+	Logger      *slog.Logger
+	RetryPolicy RetryPolicy
 }
 
 type newClientOption func(*Client)
@@ -64,11 +65,12 @@ func NewClient(token string, opts ...newClientOption) *Client {
 	defaultLogger := slog.New(h)
 
 	c := &Client{
-		Token:      token,
-		Logger:     defaultLogger,
-		HTTPClient: http.DefaultClient,
-		Host:       DefaultHost,
-		PageSize:   100,
+		Token:       token,
+		Logger:      defaultLogger,
+		HTTPClient:  http.DefaultClient,
+		Host:        DefaultHost,
+		PageSize:    100,
+		RetryPolicy: DefaultRetryPolicy,
 	}
 
 	for _, opt := range opts {
@@ -96,29 +98,69 @@ func (c *Client) makeRequest(req *http.Request) ([]byte, error) {
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
+	for attempt := 1; ; attempt++ {
+		resp, body, err := c.do(req)
+		if (err == nil && !shouldRetry(resp)) || !c.RetryPolicy.canRetry(req, attempt) {
+			if err != nil {
+				return nil, err
+			}
+
+			return handleResponse(req, resp, body)
+		}
+
+		wait, ok := c.RetryPolicy.backoff(attempt, resp)
+		if deadline, set := req.Context().Deadline(); set && time.Until(deadline) < wait {
+			// The request would run out of time before it could be retried
+			ok = false
+		}
+		if !ok {
+			c.Logger.Warn("not retrying request, the wait would be too long", "attempt", attempt, "wait", wait, "url", req.URL.String())
+			if err != nil {
+				return nil, err
+			}
+
+			return handleResponse(req, resp, body)
+		}
+
+		if err != nil {
+			c.Logger.Warn("retrying request", "attempt", attempt, "wait", wait, "url", req.URL.String(), "error", err)
+		} else {
+			c.Logger.Warn("retrying request", "attempt", attempt, "wait", wait, "url", req.URL.String(), "status", resp.Status)
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, fmt.Errorf("failed to make request: %w", req.Context().Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// do makes a single attempt at a request, returning the response and its fully read body
+func (c *Client) do(req *http.Request) (*http.Response, []byte, error) {
 	c.Logger.Info("->", "method", req.Method, "url", req.URL.String())
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, nil, fmt.Errorf("failed to make request: %w", err)
 	}
 
+	defer resp.Body.Close()
+
 	c.Logger.Info("<-", "status", resp.Status, "url", req.URL.String())
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, nil, fmt.Errorf("failed to read response body: %w", err)
 	}
-	resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		var errResp ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			return nil, fmt.Errorf("failed to decode error response: %w", err)
-		}
+	return resp, body, nil
+}
 
-		return nil, &errResp
+func handleResponse(req *http.Request, resp *http.Response, body []byte) ([]byte, error) {
+	if resp.StatusCode >= 400 {
+		return nil, newAPIError(req, resp, body)
 	}
 
-	return body, err
+	return body, nil
 }
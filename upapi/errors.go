@@ -0,0 +1,110 @@
+package upapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinels for the kinds of failure callers usually want to handle differently. They match any *APIError with the
+// corresponding status via errors.Is
+var (
+	ErrUnauthorized = errors.New("up API request unauthorized")
+	ErrNotFound     = errors.New("up API resource not found")
+	ErrRateLimited  = errors.New("up API rate limit exceeded")
+)
+
+// requestIDHeaders are the headers that identify a request to Up and the infrastructure in front of it, which are worth
+// quoting when asking Up for help
+var requestIDHeaders = []string{"X-Request-Id", "X-Amzn-Requestid", "X-Amz-Cf-Id", "Cf-Ray"}
+
+// APIError is returned when Up responds to a request with an error status
+type APIError struct {
+	StatusCode int
+	Method     string
+	URL        string
+	// RequestIDs holds whichever of the request ID headers Up sent back, keyed by header name
+	RequestIDs map[string]string
+	// Body is the raw response body
+	Body []byte
+	// Response is the error body parsed as Up's error format, or nil if it wasn't in that format
+	Response *ErrorResponse
+}
+
+func newAPIError(req *http.Request, resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		RequestIDs: make(map[string]string),
+		Body:       body,
+	}
+
+	for _, h := range requestIDHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			e.RequestIDs[h] = v
+		}
+	}
+
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && len(errResp.Errors) > 0 {
+		e.Response = &errResp
+	}
+
+	return e
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("Up API returned %d %s for %s %s", e.StatusCode, http.StatusText(e.StatusCode), e.Method, e.URL)
+
+	var details []string
+	if e.Response != nil {
+		for _, obj := range e.Response.Errors {
+			details = append(details, obj.Detail)
+		}
+	}
+	if len(details) > 0 {
+		msg += ": " + strings.Join(details, "; ")
+	}
+
+	return msg
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// Unwrap exposes the parsed error body, so that errors.As still finds an *ErrorResponse
+func (e *APIError) Unwrap() error {
+	if e.Response == nil {
+		return nil
+	}
+
+	return e.Response
+}
+
+// IsUnauthorized returns whether err came from Up rejecting the token
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, ErrUnauthorized)
+}
+
+// IsNotFound returns whether err came from Up not finding the requested resource
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsRateLimited returns whether err came from Up rate limiting requests, even after retries
+func IsRateLimited(err error) bool {
+	return errors.Is(err, ErrRateLimited)
+}
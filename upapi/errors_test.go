@@ -0,0 +1,55 @@
+package upapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-123")
+		switch r.URL.Path {
+		case "/accounts/revoked":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors":[{"status":"401","title":"Not Authorized","detail":"The request was not authenticated."}]}`))
+		default:
+			// Not every error comes from Up itself, eg a proxy in the way
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<html>not found</html>`))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient("token", WithHost(srv.URL), WithQuiet(), WithRetryPolicy(NoRetries))
+
+	_, err := c.GetAccount(context.Background(), "revoked")
+	if !IsUnauthorized(err) || IsNotFound(err) || IsRateLimited(err) {
+		t.Errorf("expected only IsUnauthorized to be true, got %v", err)
+	}
+
+	var errResp *ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Errors[0].Detail != "The request was not authenticated." {
+		t.Errorf("expected the parsed error response to still be available, got %v", err)
+	}
+
+	_, err = c.GetAccount(context.Background(), "missing")
+	if !IsNotFound(err) {
+		t.Errorf("expected IsNotFound to be true, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *APIError, got %T", err)
+	}
+
+	if apiErr.Response != nil || string(apiErr.Body) != "<html>not found</html>" {
+		t.Errorf("expected the raw body to be kept when it isn't an Up error, got %+v", apiErr)
+	}
+
+	if apiErr.RequestIDs["X-Request-Id"] != "req-123" {
+		t.Errorf("expected request ID req-123, got %v", apiErr.RequestIDs)
+	}
+}
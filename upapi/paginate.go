@@ -0,0 +1,55 @@
+package upapi
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/url"
+)
+
+// paginate calls list repeatedly, following each response's next link until there are no more pages, and yields every
+// item along the way. Only one page is held in memory at a time, and stopping the iteration early stops fetching pages.
+// setAfter sets the page[after] cursor on the params for the next call
+func paginate[T, P any](ctx context.Context, what string, params P, setAfter func(*P, string), list func(context.Context, P) (*Response[[]T], error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		for {
+			resp, err := list(ctx, params)
+			if err != nil {
+				yield(zero, fmt.Errorf("failed to list %s: %w", what, err))
+				return
+			}
+
+			for _, item := range resp.Data {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if resp.Links.Next == nil {
+				return
+			}
+
+			nextURL, err := url.Parse(*resp.Links.Next)
+			if err != nil {
+				yield(zero, fmt.Errorf("failed to parse next URL: %w", err))
+				return
+			}
+
+			setAfter(&params, nextURL.Query().Get("page[after]"))
+		}
+	}
+}
+
+// collect gathers every item from a paginated sequence into a slice, stopping at the first error
+func collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var items []T
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,53 @@
+package upapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTransactionsPaginates(t *testing.T) {
+	var calls atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Query().Get("page[after]") {
+		case "":
+			fmt.Fprintf(w, `{"data":[{"id":"1"},{"id":"2"}],"links":{"next":"%s/transactions?page%%5Bafter%%5D=2"}}`, srv.URL)
+		case "2":
+			fmt.Fprintf(w, `{"data":[{"id":"3"}],"links":{"next":null}}`)
+		default:
+			t.Errorf("unexpected page[after] %q", r.URL.Query().Get("page[after]"))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient("token", WithQuiet(), WithHost(srv.URL))
+
+	xacts, err := c.PaginateAllTransactions(context.Background(), ListTransactionsParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(xacts) != 3 || xacts[2].ID != "3" {
+		t.Errorf("expected 3 transactions across 2 pages, got %+v", xacts)
+	}
+
+	calls.Store(0)
+	for xact, err := range c.Transactions(context.Background(), ListTransactionsParams{}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if xact.ID == "1" {
+			break
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("expected stopping early to only fetch 1 page, got %d", calls.Load())
+	}
+}
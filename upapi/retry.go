@@ -0,0 +1,101 @@
+package upapi
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Only GET and HEAD requests are ever retried, as they're the
+// only ones that are safe to repeat
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Anything less than 2 disables retries
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry. It doubles after each subsequent attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. If the server asks for a longer wait via Retry-After or Up's rate limit
+	// headers, the request isn't retried at all, since retrying any sooner would only fail again
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each backoff that's randomised, between 0 and 1
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the policy used by clients that aren't given one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.2,
+}
+
+// NoRetries is a policy that makes every request exactly once
+var NoRetries = RetryPolicy{MaxAttempts: 1}
+
+func WithRetryPolicy(policy RetryPolicy) newClientOption {
+	return func(c *Client) {
+		c.RetryPolicy = policy
+	}
+}
+
+func (p RetryPolicy) canRetry(req *http.Request, attempt int) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return attempt < p.MaxAttempts
+}
+
+// shouldRetry returns whether a response indicates a transient failure
+func shouldRetry(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff returns how long to wait after the given attempt (starting at 1) before trying again, and false if the
+// server has asked for a longer wait than MaxBackoff allows. resp may be nil if the attempt failed without a response
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) (time.Duration, bool) {
+	if wait, ok := serverRequestedWait(resp); ok {
+		return wait, wait <= p.MaxBackoff
+	}
+
+	wait := p.InitialBackoff << (attempt - 1)
+	if wait <= 0 || wait > p.MaxBackoff {
+		// <= 0 catches overflow from the shift
+		wait = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		spread := float64(wait) * p.Jitter
+		wait = time.Duration(float64(wait) - spread + rand.Float64()*2*spread)
+	}
+
+	return wait, true
+}
+
+// serverRequestedWait reads how long the server has asked us to wait, either via a standard Retry-After header or, when
+// Up says we've run out of requests, its rate limit headers
+func serverRequestedWait(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+
+		if at, err := http.ParseTime(ra); err == nil {
+			return max(time.Until(at), 0), true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset := resp.Header.Get("X-RateLimit-Reset"); reset != "" {
+			if epoch, err := strconv.ParseInt(reset, 10, 64); err == nil {
+				return max(time.Until(time.Unix(epoch, 0)), 0), true
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,113 @@
+package upapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMakeRequestRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errors":[{"status":"429","title":"Too Many Requests","detail":"slow down"}]}`))
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"errors":[]}`))
+		default:
+			w.Write([]byte(`{"data":[],"links":{}}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient("token", WithQuiet(), WithHost(srv.URL), WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}))
+
+	if _, err := c.ListAccounts(context.Background(), ListAccountsParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestMakeRequestGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"errors":[{"status":"503","title":"Service Unavailable","detail":"down"}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient("token", WithQuiet(), WithHost(srv.URL), WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}))
+
+	if _, err := c.ListAccounts(context.Background(), ListAccountsParams{}); err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls, got %d", calls.Load())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 100: 5 * time.Second} {
+		if got, ok := p.backoff(attempt, nil); got != want || !ok {
+			t.Errorf("expected backoff after attempt %d to be %s, got %s (retry: %t)", attempt, want, got, ok)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	if got, ok := p.backoff(1, resp); got != 3*time.Second || !ok {
+		t.Errorf("expected backoff to honour Retry-After, got %s (retry: %t)", got, ok)
+	}
+
+	// Retrying before the server asks would only be rate limited again, so a longer wait than MaxBackoff gives up
+	resp = &http.Response{Header: http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)}}}
+	if got, ok := p.backoff(1, resp); got <= 5*time.Second || ok {
+		t.Errorf("expected backoff to give up on a wait of about a minute, got %s (retry: %t)", got, ok)
+	}
+}
+
+func TestMakeRequestGivesUpOnLongWait(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"errors":[{"status":"429","title":"Too Many Requests","detail":"slow down"}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient("token", WithQuiet(), WithHost(srv.URL), WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}))
+
+	if _, err := c.ListAccounts(context.Background(), ListAccountsParams{}); !IsRateLimited(err) {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,110 @@
+package upapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+
+	"github.com/moskyb/upbank-fbar-calculator/qparam"
+)
+
+// Tag is a label on transactions. A tag's ID is its label, and tags only exist while some transaction has them
+type Tag struct {
+	Type          string `json:"type"`
+	ID            string `json:"id"`
+	Relationships struct {
+		Transactions struct {
+			Links *RelationshipLinks `json:"links,omitempty"`
+		} `json:"transactions"`
+	} `json:"relationships"`
+}
+
+type ListTagsParams struct {
+	Before string `qparam:"page[before]"`
+	After  string `qparam:"page[after]"`
+}
+
+// Tags iterates over every tag in use, fetching pages as they're needed
+func (c *Client) Tags(ctx context.Context, params ListTagsParams) iter.Seq2[Tag, error] {
+	return paginate(ctx, "tags", params, func(p *ListTagsParams, after string) { p.After = after }, c.ListTags)
+}
+
+func (c *Client) PaginateAllTags(ctx context.Context, params ListTagsParams) ([]Tag, error) {
+	return collect(c.Tags(ctx, params))
+}
+
+func (c *Client) ListTags(ctx context.Context, params ListTagsParams) (*Response[[]Tag], error) {
+	url, err := c.buildURL("tags")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	query, err := qparam.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode query params: %w", err)
+	}
+
+	req.URL.RawQuery = qparam.Merge(req.URL.Query(), query).Encode()
+
+	body, err := c.makeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	resp := Response[[]Tag]{}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// AddTagsToTransaction adds tags to a transaction, creating any that don't exist yet. Tags the transaction already
+// has are left alone
+func (c *Client) AddTagsToTransaction(ctx context.Context, transactionID string, tags ...string) error {
+	return c.updateTransactionTags(ctx, "POST", transactionID, tags)
+}
+
+// RemoveTagsFromTransaction removes tags from a transaction. Tags the transaction doesn't have are ignored
+func (c *Client) RemoveTagsFromTransaction(ctx context.Context, transactionID string, tags ...string) error {
+	return c.updateTransactionTags(ctx, "DELETE", transactionID, tags)
+}
+
+func (c *Client) updateTransactionTags(ctx context.Context, method, transactionID string, tags []string) error {
+	url, err := c.buildURL(fmt.Sprintf("transactions/%s/relationships/tags", transactionID))
+	if err != nil {
+		return fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	payload := struct {
+		Data []ResourceIdentifier `json:"data"`
+	}{Data: make([]ResourceIdentifier, 0, len(tags))}
+	for _, tag := range tags {
+		payload.Data = append(payload.Data, ResourceIdentifier{Type: ResourceTypeTags, ID: tag})
+	}
+
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if _, err := c.makeRequest(req); err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+
+	return nil
+}
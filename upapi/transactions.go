@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"iter"
 	"net/http"
-	"net/url"
 	"time"
 
 	"github.com/moskyb/upbank-fbar-calculator/qparam"
@@ -46,6 +46,46 @@ type Transaction struct {
 		SettledAt *time.Time `json:"settledAt,omitempty"`
 		CreatedAt time.Time  `json:"createdAt"`
 	} `json:"attributes"`
+	Relationships struct {
+		Account         Relationship[ResourceIdentifier]   `json:"account"`
+		TransferAccount Relationship[*ResourceIdentifier]  `json:"transferAccount"`
+		Category        Relationship[*ResourceIdentifier]  `json:"category"`
+		ParentCategory  Relationship[*ResourceIdentifier]  `json:"parentCategory"`
+		Tags            Relationship[[]ResourceIdentifier] `json:"tags"`
+		Attachment      Relationship[*ResourceIdentifier]  `json:"attachment"`
+	} `json:"relationships"`
+	Links *ResourceLinks `json:"links,omitempty"`
+}
+
+// AccountID is the ID of the account the transaction belongs to
+func (t Transaction) AccountID() string {
+	return t.Relationships.Account.Data.ID
+}
+
+// TransferAccountID is the ID of the other account involved if the transaction is a transfer between Up accounts, or
+// empty otherwise
+func (t Transaction) TransferAccountID() string {
+	return t.Relationships.TransferAccount.ID()
+}
+
+// CategoryID is the ID of the transaction's category, or empty if it's uncategorised
+func (t Transaction) CategoryID() string {
+	return t.Relationships.Category.ID()
+}
+
+// ParentCategoryID is the ID of the parent of the transaction's category, or empty if it's uncategorised
+func (t Transaction) ParentCategoryID() string {
+	return t.Relationships.ParentCategory.ID()
+}
+
+// TagIDs returns the IDs of the transaction's tags, which for tags are also their labels
+func (t Transaction) TagIDs() []string {
+	ids := make([]string, 0, len(t.Relationships.Tags.Data))
+	for _, tag := range t.Relationships.Tags.Data {
+		ids = append(ids, tag.ID)
+	}
+
+	return ids
 }
 
 type ListTransactionsParams struct {
@@ -59,30 +99,13 @@ type ListTransactionsParams struct {
 	After  string `qparam:"page[after]"`
 }
 
-func (c *Client) PaginateAllTransactions(ctx context.Context, params ListTransactionsParams) ([]Transaction, error) {
-	var xacts []Transaction
-	for {
-		resp, err := c.ListTransactions(ctx, params)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list transactions: %w", err)
-		}
-
-		xacts = append(xacts, resp.Data...)
-
-		if resp.Links.Next == nil {
-			break
-		}
-
-		nextURL, err := url.Parse(*resp.Links.Next)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse next URL: %w", err)
-		}
-
-		after := nextURL.Query().Get("page[after]")
-		params.After = after
-	}
+// Transactions iterates over every transaction matching params, newest first, fetching pages as they're needed
+func (c *Client) Transactions(ctx context.Context, params ListTransactionsParams) iter.Seq2[Transaction, error] {
+	return paginate(ctx, "transactions", params, setTransactionsAfter, c.ListTransactions)
+}
 
-	return xacts, nil
+func (c *Client) PaginateAllTransactions(ctx context.Context, params ListTransactionsParams) ([]Transaction, error) {
+	return collect(c.Transactions(ctx, params))
 }
 
 func (c *Client) ListTransactions(ctx context.Context, params ListTransactionsParams) (*Response[[]Transaction], error) {
@@ -116,30 +139,22 @@ func (c *Client) ListTransactions(ctx context.Context, params ListTransactionsPa
 	return &resp, nil
 }
 
-func (c *Client) PaginateAllTransactionsForAccount(ctx context.Context, accountID string, params ListTransactionsParams) ([]Transaction, error) {
-	var xacts []Transaction
-	for {
-		resp, err := c.ListTransactionsForAccount(ctx, accountID, params)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list transactions: %w", err)
-		}
-
-		xacts = append(xacts, resp.Data...)
-
-		if resp.Links.Next == nil {
-			break
-		}
+// TransactionsForAccount iterates over every transaction on an account matching params, newest first, fetching pages
+// as they're needed
+func (c *Client) TransactionsForAccount(ctx context.Context, accountID string, params ListTransactionsParams) iter.Seq2[Transaction, error] {
+	list := func(ctx context.Context, params ListTransactionsParams) (*Response[[]Transaction], error) {
+		return c.ListTransactionsForAccount(ctx, accountID, params)
+	}
 
-		nextURL, err := url.Parse(*resp.Links.Next)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse next URL: %w", err)
-		}
+	return paginate(ctx, "transactions", params, setTransactionsAfter, list)
+}
 
-		after := nextURL.Query().Get("page[after]")
-		params.After = after
-	}
+func (c *Client) PaginateAllTransactionsForAccount(ctx context.Context, accountID string, params ListTransactionsParams) ([]Transaction, error) {
+	return collect(c.TransactionsForAccount(ctx, accountID, params))
+}
 
-	return xacts, nil
+func setTransactionsAfter(p *ListTransactionsParams, after string) {
+	p.After = after
 }
 
 func (c *Client) ListTransactionsForAccount(ctx context.Context, accoundID string, params ListTransactionsParams) (*Response[[]Transaction], error) {
@@ -172,3 +187,27 @@ func (c *Client) ListTransactionsForAccount(ctx context.Context, accoundID strin
 
 	return &resp, nil
 }
+
+func (c *Client) GetTransaction(ctx context.Context, id string) (*Response[Transaction], error) {
+	path, err := c.buildURL(fmt.Sprintf("transactions/%s", id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	body, err := c.makeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	var resp Response[Transaction]
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &resp, nil
+}
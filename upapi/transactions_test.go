@@ -0,0 +1,49 @@
+package upapi
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestTransactionRelationships(t *testing.T) {
+	body := `{
+		"type": "transactions",
+		"id": "xact",
+		"attributes": {"status": "SETTLED", "description": "Transfer to Saver", "amount": {"currencyCode": "AUD", "value": "-10.00", "valueInBaseUnits": -1000}},
+		"relationships": {
+			"account": {"data": {"type": "accounts", "id": "spending"}, "links": {"related": "https://api.up.com.au/api/v1/accounts/spending"}},
+			"transferAccount": {"data": {"type": "accounts", "id": "saver"}},
+			"category": {"data": null, "links": {"self": "https://api.up.com.au/api/v1/transactions/xact/relationships/category"}},
+			"parentCategory": {"data": null},
+			"tags": {"data": [{"type": "tags", "id": "fbar-reviewed"}, {"type": "tags", "id": "internal-transfer"}], "links": {"self": "https://api.up.com.au/api/v1/transactions/xact/relationships/tags"}},
+			"attachment": {"data": null}
+		},
+		"links": {"self": "https://api.up.com.au/api/v1/transactions/xact"}
+	}`
+
+	var x Transaction
+	if err := json.Unmarshal([]byte(body), &x); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if x.AccountID() != "spending" || x.TransferAccountID() != "saver" {
+		t.Errorf("expected transfer from spending to saver, got %q to %q", x.AccountID(), x.TransferAccountID())
+	}
+
+	if x.CategoryID() != "" || x.ParentCategoryID() != "" {
+		t.Errorf("expected no category, got %q (parent %q)", x.CategoryID(), x.ParentCategoryID())
+	}
+
+	if !slices.Equal(x.TagIDs(), []string{"fbar-reviewed", "internal-transfer"}) {
+		t.Errorf("expected two tags, got %v", x.TagIDs())
+	}
+
+	if x.Relationships.Tags.Links == nil || x.Relationships.Tags.Links.Self == nil {
+		t.Errorf("expected tags relationship to have a self link, but it didn't")
+	}
+
+	if x.Links == nil || x.Links.Self != "https://api.up.com.au/api/v1/transactions/xact" {
+		t.Errorf("expected transaction to have a self link, got %+v", x.Links)
+	}
+}
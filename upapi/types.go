@@ -15,12 +15,67 @@ const (
 	OwnershipTypeJoint      = "JOINT"
 )
 
+const (
+	TransactionStatusHeld    = "HELD"
+	TransactionStatusSettled = "SETTLED"
+)
+
 type Money struct {
 	CurrencyCode     string `json:"currencyCode"`
 	Value            string `json:"value"`
 	ValueInBaseUnits int    `json:"valueInBaseUnits"`
 }
 
+const (
+	ResourceTypeAccounts     = "accounts"
+	ResourceTypeTransactions = "transactions"
+	ResourceTypeCategories   = "categories"
+	ResourceTypeTags         = "tags"
+	ResourceTypeAttachments  = "attachments"
+	ResourceTypeWebhooks     = "webhooks"
+
+	ResourceTypeWebhookEvents       = "webhook-events"
+	ResourceTypeWebhookDeliveryLogs = "webhook-delivery-logs"
+)
+
+// ResourceIdentifier points at another resource, such as the account a transaction belongs to
+type ResourceIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Relationship is a link from one resource to others. T is ResourceIdentifier for relationships that are always
+// present, *ResourceIdentifier for those that may be null, and []ResourceIdentifier for to-many relationships
+type Relationship[T any] struct {
+	Data  T                  `json:"data"`
+	Links *RelationshipLinks `json:"links,omitempty"`
+}
+
+// ID returns the ID of the related resource for an optional to-one relationship, or empty if there isn't one
+func (r Relationship[T]) ID() string {
+	switch data := any(r.Data).(type) {
+	case ResourceIdentifier:
+		return data.ID
+	case *ResourceIdentifier:
+		if data != nil {
+			return data.ID
+		}
+	}
+
+	return ""
+}
+
+type RelationshipLinks struct {
+	// Self is the URL for managing the relationship itself, eg adding or removing tags
+	Self *string `json:"self,omitempty"`
+	// Related is the URL of the related resource
+	Related *string `json:"related,omitempty"`
+}
+
+type ResourceLinks struct {
+	Self string `json:"self"`
+}
+
 type Response[T any] struct {
 	Data  T `json:"data"`
 	Links struct {
@@ -30,15 +85,17 @@ type Response[T any] struct {
 }
 
 type ErrorResponse struct {
-	Errors []struct {
-		Status string `json:"status"`
-		Title  string `json:"title"`
-		Detail string `json:"detail"`
-		Source *struct {
-			Parameter string `json:"parameter"`
-			Pointer   string `json:"pointer"`
-		} `json:"source,omitempty"`
-	} `json:"errors"`
+	Errors []ErrorObject `json:"errors"`
+}
+
+type ErrorObject struct {
+	Status string `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Source *struct {
+		Parameter string `json:"parameter"`
+		Pointer   string `json:"pointer"`
+	} `json:"source,omitempty"`
 }
 
 func (e *ErrorResponse) Error() string {
@@ -0,0 +1,42 @@
+package upapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type PingResponse struct {
+	Meta struct {
+		// ID is the ID of the user the token belongs to
+		ID          string `json:"id"`
+		StatusEmoji string `json:"statusEmoji"`
+	} `json:"meta"`
+}
+
+// Ping checks that the client's token is accepted, without fetching any data. An invalid token gives an error matching
+// ErrUnauthorized
+func (c *Client) Ping(ctx context.Context) (*PingResponse, error) {
+	path, err := c.buildURL("util/ping")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	body, err := c.makeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	var resp PingResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &resp, nil
+}
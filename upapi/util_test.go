@@ -0,0 +1,34 @@
+package upapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/util/ping" || r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors":[{"status":"401","title":"Not Authorized","detail":"The request was not authenticated."}]}`))
+			return
+		}
+		w.Write([]byte(`{"meta":{"id":"user","statusEmoji":"⚡️"}}`))
+	}))
+	defer srv.Close()
+
+	ping, err := NewClient("good", WithHost(srv.URL), WithQuiet()).Ping(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ping.Meta.ID != "user" {
+		t.Errorf("expected ping for user, got %+v", ping.Meta)
+	}
+
+	_, err = NewClient("bad", WithHost(srv.URL), WithQuiet()).Ping(context.Background())
+	if !IsUnauthorized(err) {
+		t.Errorf("expected an unauthorized error for a bad token, got %v", err)
+	}
+}
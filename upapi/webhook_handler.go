@@ -0,0 +1,96 @@
+package upapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// WebhookSignatureHeader is the header Up signs webhook deliveries with: the hex encoded HMAC-SHA256 of the raw request
+// body, keyed with the webhook's secret key
+const WebhookSignatureHeader = "X-Up-Authenticity-Signature"
+
+// maxWebhookBodySize is far more than any event Up sends, and stops anyone posting an endless body at the handler
+const maxWebhookBodySize = 1 << 20
+
+// WebhookCallback is called with each transaction event a WebhookHandler receives. Returning an error makes the handler
+// respond with a server error, so that Up logs the delivery as failed
+type WebhookCallback func(ctx context.Context, event WebhookEvent) error
+
+// WebhookHandler is an http.Handler that receives events from an Up webhook. It rejects anything not signed with the
+// webhook's secret key, acknowledges pings, and hands TRANSACTION_CREATED, TRANSACTION_SETTLED and TRANSACTION_DELETED
+// events to its callback
+type WebhookHandler struct {
+	Logger *slog.Logger
+
+	secretKey string
+	callback  WebhookCallback
+}
+
+func NewWebhookHandler(secretKey string, callback WebhookCallback) *WebhookHandler {
+	return &WebhookHandler{
+		Logger:    slog.Default(),
+		secretKey: secretKey,
+		callback:  callback,
+	}
+}
+
+// VerifyWebhookSignature checks that signature, as sent in WebhookSignatureHeader, is valid for body
+func VerifyWebhookSignature(secretKey string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write(body)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if !VerifyWebhookSignature(h.secretKey, body, r.Header.Get(WebhookSignatureHeader)) {
+		h.Logger.Warn("rejected webhook delivery with a bad signature", "remote_addr", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var payload Response[WebhookEvent]
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "failed to decode event", http.StatusBadRequest)
+		return
+	}
+
+	event := payload.Data
+	switch event.Attributes.EventType {
+	case WebhookEventTypeTransactionCreated, WebhookEventTypeTransactionSettled, WebhookEventTypeTransactionDeleted:
+		if err := h.callback(r.Context(), event); err != nil {
+			h.Logger.Error("failed to handle webhook event", "event_id", event.ID, "event_type", event.Attributes.EventType, "error", err)
+			http.Error(w, "failed to handle event", http.StatusInternalServerError)
+			return
+		}
+	case WebhookEventTypePing:
+		h.Logger.Info("received webhook ping", "event_id", event.ID)
+	default:
+		h.Logger.Debug("ignoring unknown webhook event", "event_id", event.ID, "event_type", event.Attributes.EventType)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,58 @@
+package upapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	const secret = "shh"
+
+	var got []WebhookEvent
+	h := NewWebhookHandler(secret, func(_ context.Context, event WebhookEvent) error {
+		got = append(got, event)
+		return nil
+	})
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	deliver := func(body, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		req.Header.Set(WebhookSignatureHeader, signature)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	settled := `{"data": {"type": "webhook-events", "id": "event", "attributes": {"eventType": "TRANSACTION_SETTLED", "createdAt": "2023-03-01T12:00:00+11:00"}, "relationships": {"webhook": {"data": {"type": "webhooks", "id": "hook"}}, "transaction": {"data": {"type": "transactions", "id": "xact"}}}}}`
+	ping := `{"data": {"type": "webhook-events", "id": "ping", "attributes": {"eventType": "PING", "createdAt": "2023-03-01T12:00:00+11:00"}, "relationships": {"webhook": {"data": {"type": "webhooks", "id": "hook"}}}}}`
+
+	if code := deliver(settled, sign(settled+" ")); code != http.StatusUnauthorized {
+		t.Errorf("expected a bad signature to be rejected with 401, got %d", code)
+	}
+
+	if code := deliver(ping, sign(ping)); code != http.StatusOK {
+		t.Errorf("expected a ping to be acknowledged with 200, got %d", code)
+	}
+
+	if code := deliver(settled, sign(settled)); code != http.StatusOK {
+		t.Errorf("expected a signed event to be accepted with 200, got %d", code)
+	}
+
+	if len(got) != 1 || got[0].TransactionID() != "xact" || got[0].Attributes.EventType != WebhookEventTypeTransactionSettled {
+		t.Errorf("expected the callback to get only the settled event for xact, got %+v", got)
+	}
+}
@@ -0,0 +1,228 @@
+package upapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/qparam"
+)
+
+const (
+	WebhookEventTypeTransactionCreated = "TRANSACTION_CREATED"
+	WebhookEventTypeTransactionSettled = "TRANSACTION_SETTLED"
+	WebhookEventTypeTransactionDeleted = "TRANSACTION_DELETED"
+	WebhookEventTypePing               = "PING"
+)
+
+const (
+	WebhookDeliveryStatusDelivered       = "DELIVERED"
+	WebhookDeliveryStatusUndeliverable   = "UNDELIVERABLE"
+	WebhookDeliveryStatusBadResponseCode = "BAD_RESPONSE_CODE"
+)
+
+type Webhook struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Attributes struct {
+		URL         string  `json:"url"`
+		Description *string `json:"description"`
+		// SecretKey signs every event delivered to the webhook. Up only returns it when the webhook is created, so it
+		// has to be saved then
+		SecretKey string    `json:"secretKey,omitempty"`
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"attributes"`
+	Relationships struct {
+		Logs struct {
+			Links *RelationshipLinks `json:"links,omitempty"`
+		} `json:"logs"`
+	} `json:"relationships"`
+	Links *ResourceLinks `json:"links,omitempty"`
+}
+
+// WebhookEvent is something that happened that Up tells webhooks about, such as a transaction being created
+type WebhookEvent struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Attributes struct {
+		EventType string    `json:"eventType"`
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"attributes"`
+	Relationships struct {
+		Webhook     Relationship[ResourceIdentifier]  `json:"webhook"`
+		Transaction *Relationship[ResourceIdentifier] `json:"transaction,omitempty"`
+	} `json:"relationships"`
+}
+
+// TransactionID is the ID of the transaction the event is about, or empty for events that aren't about one, like pings
+func (e WebhookEvent) TransactionID() string {
+	if e.Relationships.Transaction == nil {
+		return ""
+	}
+
+	return e.Relationships.Transaction.ID()
+}
+
+// WebhookDeliveryLog is a record of Up trying to deliver an event to a webhook
+type WebhookDeliveryLog struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Attributes struct {
+		Request struct {
+			Body string `json:"body"`
+		} `json:"request"`
+		// Response is nil if Up couldn't get a response at all
+		Response *struct {
+			StatusCode int    `json:"statusCode"`
+			Body       string `json:"body"`
+		} `json:"response"`
+		DeliveryStatus string    `json:"deliveryStatus"`
+		CreatedAt      time.Time `json:"createdAt"`
+	} `json:"attributes"`
+	Relationships struct {
+		WebhookEvent Relationship[ResourceIdentifier] `json:"webhookEvent"`
+	} `json:"relationships"`
+}
+
+type ListWebhooksParams struct {
+	Before string `qparam:"page[before]"`
+	After  string `qparam:"page[after]"`
+}
+
+// Webhooks iterates over every webhook, oldest first, fetching pages as they're needed
+func (c *Client) Webhooks(ctx context.Context, params ListWebhooksParams) iter.Seq2[Webhook, error] {
+	return paginate(ctx, "webhooks", params, func(p *ListWebhooksParams, after string) { p.After = after }, c.ListWebhooks)
+}
+
+func (c *Client) PaginateAllWebhooks(ctx context.Context, params ListWebhooksParams) ([]Webhook, error) {
+	return collect(c.Webhooks(ctx, params))
+}
+
+func (c *Client) ListWebhooks(ctx context.Context, params ListWebhooksParams) (*Response[[]Webhook], error) {
+	var resp Response[[]Webhook]
+	if err := c.webhookRequest(ctx, "GET", "webhooks", params, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (c *Client) GetWebhook(ctx context.Context, id string) (*Response[Webhook], error) {
+	var resp Response[Webhook]
+	if err := c.webhookRequest(ctx, "GET", fmt.Sprintf("webhooks/%s", id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// CreateWebhook registers a URL to be sent events. The returned webhook's SecretKey is needed to verify them, and can't
+// be fetched again later. Up sends a ping to the URL straight away
+func (c *Client) CreateWebhook(ctx context.Context, url, description string) (*Response[Webhook], error) {
+	payload := struct {
+		Data struct {
+			Attributes struct {
+				URL         string `json:"url"`
+				Description string `json:"description,omitempty"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}{}
+	payload.Data.Attributes.URL = url
+	payload.Data.Attributes.Description = description
+
+	var resp Response[Webhook]
+	if err := c.webhookRequest(ctx, "POST", "webhooks", nil, payload, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	return c.webhookRequest(ctx, "DELETE", fmt.Sprintf("webhooks/%s", id), nil, nil, nil)
+}
+
+// PingWebhook asks Up to send a PING event to the webhook, and returns the event it sent
+func (c *Client) PingWebhook(ctx context.Context, id string) (*Response[WebhookEvent], error) {
+	var resp Response[WebhookEvent]
+	if err := c.webhookRequest(ctx, "POST", fmt.Sprintf("webhooks/%s/ping", id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+type ListWebhookLogsParams struct {
+	Before string `qparam:"page[before]"`
+	After  string `qparam:"page[after]"`
+}
+
+// WebhookLogs iterates over the delivery logs for a webhook, newest first, fetching pages as they're needed
+func (c *Client) WebhookLogs(ctx context.Context, webhookID string, params ListWebhookLogsParams) iter.Seq2[WebhookDeliveryLog, error] {
+	list := func(ctx context.Context, params ListWebhookLogsParams) (*Response[[]WebhookDeliveryLog], error) {
+		return c.ListWebhookLogs(ctx, webhookID, params)
+	}
+
+	return paginate(ctx, "webhook logs", params, func(p *ListWebhookLogsParams, after string) { p.After = after }, list)
+}
+
+func (c *Client) ListWebhookLogs(ctx context.Context, webhookID string, params ListWebhookLogsParams) (*Response[[]WebhookDeliveryLog], error) {
+	var resp Response[[]WebhookDeliveryLog]
+	if err := c.webhookRequest(ctx, "GET", fmt.Sprintf("webhooks/%s/logs", webhookID), params, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// webhookRequest makes a request to one of the webhook endpoints. params are encoded into the query string and payload
+// into the body if they're non-nil, and the response is decoded into out if it's non-nil
+func (c *Client) webhookRequest(ctx context.Context, method, path string, params, payload, out any) error {
+	url, err := c.buildURL(path)
+	if err != nil {
+		return fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if params != nil {
+		query, err := qparam.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode query params: %w", err)
+		}
+
+		req.URL.RawQuery = qparam.Merge(req.URL.Query(), query).Encode()
+	}
+
+	body, err := c.makeRequest(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package upapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// Fixtures is the data a Server serves. It has the same JSON shape as the Up API's own resources, so fixture files
+// can be built by pasting in real (or redacted) responses
+type Fixtures struct {
+	Accounts []upapi.Account `json:"accounts"`
+	// Transactions maps account IDs to the transactions on that account, in any order
+	Transactions map[string][]upapi.Transaction `json:"transactions"`
+	Categories   []upapi.Category               `json:"categories"`
+}
+
+// LoadFixturesFile reads fixtures from a JSON file
+func LoadFixturesFile(path string) (*Fixtures, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+
+	var f Fixtures
+	if err := json.Unmarshal(body, &f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fixtures: %w", err)
+	}
+
+	return &f, nil
+}
+
+// Money builds an AUD upapi.Money from an amount in cents
+func Money(cents int) upapi.Money {
+	sign, abs := "", cents
+	if cents < 0 {
+		sign, abs = "-", -cents
+	}
+
+	return upapi.Money{
+		CurrencyCode:     "AUD",
+		Value:            fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100),
+		ValueInBaseUnits: cents,
+	}
+}
+
+// Account builds an individually owned transactional account
+func Account(id, displayName string, balance int, createdAt time.Time) upapi.Account {
+	acc := upapi.Account{Type: "accounts", ID: id}
+	acc.Attributes.DisplayName = displayName
+	acc.Attributes.AccountType = upapi.AccountTypeTransactional
+	acc.Attributes.OwnershipType = upapi.OwnershipTypeIndividual
+	acc.Attributes.Balance = Money(balance)
+	acc.Attributes.CreatedAt = createdAt
+	return acc
+}
+
+// Transaction builds a settled transaction
+func Transaction(id, description string, amount int, createdAt time.Time) upapi.Transaction {
+	xact := upapi.Transaction{Type: "transactions", ID: id}
+	xact.Attributes.Status = upapi.TransactionStatusSettled
+	xact.Attributes.Description = description
+	xact.Attributes.Amount = Money(amount)
+	xact.Attributes.CreatedAt = createdAt
+	settledAt := createdAt
+	xact.Attributes.SettledAt = &settledAt
+	return xact
+}
+
+// Category builds a category. An empty parentID makes it a top level category. The server fills in children
+func Category(id, name, parentID string) upapi.Category {
+	cat := upapi.Category{Type: upapi.ResourceTypeCategories, ID: id}
+	cat.Attributes.Name = name
+	if parentID != "" {
+		cat.Relationships.Parent.Data = &upapi.ResourceIdentifier{Type: upapi.ResourceTypeCategories, ID: parentID}
+	}
+
+	return cat
+}
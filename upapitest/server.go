@@ -0,0 +1,527 @@
+// Package upapitest provides an in-process fake of the Up API for tests, so that code using upapi.Client can be
+// exercised without a real UP_TOKEN
+package upapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/moskyb/upbank-fbar-calculator/upapi"
+)
+
+// Token is the only token the fake server accepts
+const Token = "up:yeah:test"
+
+const defaultPageSize = 10
+
+// Server is a fake Up API serving fixtures. Use Client, or upapi.WithHost(s.URL), to point a client at it
+type Server struct {
+	*httptest.Server
+
+	mtx          sync.Mutex
+	fixtures     Fixtures
+	failures     []int
+	pathFailures map[string][]int
+	rateLimit    int
+	remaining    int
+	requestCount int
+}
+
+// NewServer starts a fake Up API serving the given fixtures. Callers should Close it when they're done
+func NewServer(fixtures Fixtures) *Server {
+	s := &Server{
+		fixtures: Fixtures{
+			Accounts:     slices.Clone(fixtures.Accounts),
+			Transactions: make(map[string][]upapi.Transaction, len(fixtures.Transactions)),
+			Categories:   withChildren(fixtures.Categories),
+		},
+		pathFailures: make(map[string][]int),
+	}
+	for id, xacts := range fixtures.Transactions {
+		s.fixtures.Transactions[id] = withAccount(id, xacts)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /util/ping", s.ping)
+	mux.HandleFunc("GET /accounts", s.listAccounts)
+	mux.HandleFunc("GET /accounts/{id}", s.getAccount)
+	mux.HandleFunc("GET /accounts/{id}/transactions", s.listTransactionsForAccount)
+	mux.HandleFunc("GET /transactions", s.listTransactions)
+	mux.HandleFunc("GET /transactions/{id}", s.getTransaction)
+	mux.HandleFunc("PATCH /transactions/{id}/relationships/category", s.categorizeTransaction)
+	mux.HandleFunc("POST /transactions/{id}/relationships/tags", s.updateTags)
+	mux.HandleFunc("DELETE /transactions/{id}/relationships/tags", s.updateTags)
+	mux.HandleFunc("GET /tags", s.listTags)
+	mux.HandleFunc("GET /categories", s.listCategories)
+	mux.HandleFunc("GET /categories/{id}", s.getCategory)
+
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// Client returns a quiet client pointed at the server and authenticated with Token
+func (s *Server) Client(opts ...func(*upapi.Client)) *upapi.Client {
+	c := upapi.NewClient(Token, upapi.WithQuiet(), upapi.WithHost(s.URL))
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// AddAccount adds an account, along with any transactions on it
+func (s *Server) AddAccount(acc upapi.Account, xacts ...upapi.Transaction) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.fixtures.Accounts = append(s.fixtures.Accounts, acc)
+	s.fixtures.Transactions[acc.ID] = append(s.fixtures.Transactions[acc.ID], withAccount(acc.ID, xacts)...)
+}
+
+// AddTransactions adds transactions to an existing account. The account's balance is left alone
+func (s *Server) AddTransactions(accountID string, xacts ...upapi.Transaction) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.fixtures.Transactions[accountID] = append(s.fixtures.Transactions[accountID], withAccount(accountID, xacts)...)
+}
+
+// withAccount fills in the account relationship on transactions that don't already have one
+func withAccount(accountID string, xacts []upapi.Transaction) []upapi.Transaction {
+	xacts = slices.Clone(xacts)
+	for i := range xacts {
+		if xacts[i].Relationships.Account.Data.ID == "" {
+			xacts[i].Relationships.Account.Data = upapi.ResourceIdentifier{Type: upapi.ResourceTypeAccounts, ID: accountID}
+		}
+	}
+
+	return xacts
+}
+
+// withChildren fills in the children relationship of each category from the other categories' parents
+func withChildren(categories []upapi.Category) []upapi.Category {
+	categories = slices.Clone(categories)
+	for i := range categories {
+		children := []upapi.ResourceIdentifier{}
+		for _, cat := range categories {
+			if cat.ParentID() == categories[i].ID {
+				children = append(children, upapi.ResourceIdentifier{Type: upapi.ResourceTypeCategories, ID: cat.ID})
+			}
+		}
+		categories[i].Relationships.Children.Data = children
+	}
+
+	return categories
+}
+
+// FailNext makes the next requests fail with the given HTTP statuses, one status per request
+func (s *Server) FailNext(statuses ...int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.failures = append(s.failures, statuses...)
+}
+
+// FailNextOn makes the next requests to path, such as /accounts/{id}/transactions, fail with the given HTTP statuses,
+// one status per request. Requests to other paths aren't affected
+func (s *Server) FailNextOn(path string, statuses ...int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.pathFailures[path] = append(s.pathFailures[path], statuses...)
+}
+
+// SetRateLimit makes the server respond 429 Too Many Requests after every limit requests, telling the client to retry
+// immediately. A limit of zero disables rate limiting
+func (s *Server) SetRateLimit(limit int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.rateLimit = limit
+	s.remaining = limit
+}
+
+// RequestCount is the number of requests the server has received, including failed ones
+func (s *Server) RequestCount() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.requestCount
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mtx.Lock()
+		s.requestCount++
+
+		if len(s.failures) > 0 {
+			status := s.failures[0]
+			s.failures = s.failures[1:]
+			s.mtx.Unlock()
+
+			writeError(w, status, "Injected failure")
+			return
+		}
+
+		if failures := s.pathFailures[r.URL.Path]; len(failures) > 0 {
+			s.pathFailures[r.URL.Path] = failures[1:]
+			s.mtx.Unlock()
+
+			writeError(w, failures[0], "Injected failure")
+			return
+		}
+
+		if s.rateLimit > 0 {
+			if s.remaining == 0 {
+				s.remaining = s.rateLimit
+				s.mtx.Unlock()
+
+				w.Header().Set("Retry-After", "0")
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+				return
+			}
+
+			s.remaining--
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.remaining))
+		}
+		s.mtx.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer "+Token {
+			writeError(w, http.StatusUnauthorized, "The request was not authenticated because no valid credential was found in the Authorization header, or the Authorization header was not present.")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
+	var resp upapi.PingResponse
+	resp.Meta.ID = "upapitest"
+	resp.Meta.StatusEmoji = "⚡️"
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) listAccounts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.mtx.Lock()
+	var accounts []upapi.Account
+	for _, acc := range s.fixtures.Accounts {
+		if t := q.Get("filter[accountType]"); t != "" && acc.Attributes.AccountType != t {
+			continue
+		}
+		if o := q.Get("filter[ownershipType]"); o != "" && acc.Attributes.OwnershipType != o {
+			continue
+		}
+		accounts = append(accounts, acc)
+	}
+	s.mtx.Unlock()
+
+	writePage(w, r, accounts)
+}
+
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request) {
+	acc, ok := s.account(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "The resource you requested could not be found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, upapi.Response[upapi.Account]{Data: acc})
+}
+
+func (s *Server) listTransactionsForAccount(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.account(id); !ok {
+		writeError(w, http.StatusNotFound, "The resource you requested could not be found.")
+		return
+	}
+
+	s.mtx.Lock()
+	xacts := slices.Clone(s.fixtures.Transactions[id])
+	s.mtx.Unlock()
+
+	s.writeTransactions(w, r, xacts)
+}
+
+func (s *Server) listTransactions(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	var xacts []upapi.Transaction
+	for _, acc := range s.fixtures.Accounts {
+		xacts = append(xacts, s.fixtures.Transactions[acc.ID]...)
+	}
+	s.mtx.Unlock()
+
+	s.writeTransactions(w, r, xacts)
+}
+
+func (s *Server) getTransaction(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	xact := s.transaction(r.PathValue("id"))
+	var found upapi.Transaction
+	if xact != nil {
+		found = *xact
+	}
+	s.mtx.Unlock()
+
+	if xact == nil {
+		writeError(w, http.StatusNotFound, "The resource you requested could not be found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, upapi.Response[upapi.Transaction]{Data: found})
+}
+
+func (s *Server) writeTransactions(w http.ResponseWriter, r *http.Request, xacts []upapi.Transaction) {
+	q := r.URL.Query()
+
+	var since, until time.Time
+	for param, t := range map[string]*time.Time{"filter[since]": &since, "filter[until]": &until} {
+		if v := q.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("%s is not a valid RFC 3339 date-time", param))
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	xacts = slices.DeleteFunc(xacts, func(x upapi.Transaction) bool {
+		if status := q.Get("filter[status]"); status != "" && x.Attributes.Status != status {
+			return true
+		}
+		if cat := q.Get("filter[category]"); cat != "" && x.CategoryID() != cat && x.ParentCategoryID() != cat {
+			return true
+		}
+		if !since.IsZero() && x.Attributes.CreatedAt.Before(since) {
+			return true
+		}
+		if !until.IsZero() && !x.Attributes.CreatedAt.Before(until) {
+			return true
+		}
+		return false
+	})
+
+	// Up returns transactions newest first
+	slices.SortStableFunc(xacts, func(i, j upapi.Transaction) int {
+		return j.Attributes.CreatedAt.Compare(i.Attributes.CreatedAt)
+	})
+
+	writePage(w, r, xacts)
+}
+
+func (s *Server) categorizeTransaction(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Data *upapi.ResourceIdentifier `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "The request body could not be parsed as JSON.")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var parent *upapi.ResourceIdentifier
+	if body.Data != nil {
+		idx := slices.IndexFunc(s.fixtures.Categories, func(cat upapi.Category) bool { return cat.ID == body.Data.ID })
+		if idx == -1 {
+			writeError(w, http.StatusUnprocessableEntity, "The category does not exist.")
+			return
+		}
+		parent = s.fixtures.Categories[idx].Relationships.Parent.Data
+	}
+
+	xact := s.transaction(r.PathValue("id"))
+	if xact == nil {
+		writeError(w, http.StatusNotFound, "The resource you requested could not be found.")
+		return
+	}
+
+	xact.Relationships.Category.Data = body.Data
+	xact.Relationships.ParentCategory.Data = parent
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) updateTags(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Data []upapi.ResourceIdentifier `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "The request body could not be parsed as JSON.")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	xact := s.transaction(r.PathValue("id"))
+	if xact == nil {
+		writeError(w, http.StatusNotFound, "The resource you requested could not be found.")
+		return
+	}
+
+	tags := xact.Relationships.Tags.Data
+	for _, tag := range body.Data {
+		has := slices.ContainsFunc(tags, func(t upapi.ResourceIdentifier) bool { return t.ID == tag.ID })
+		switch {
+		case r.Method == http.MethodPost && !has:
+			tags = append(tags, upapi.ResourceIdentifier{Type: upapi.ResourceTypeTags, ID: tag.ID})
+		case r.Method == http.MethodDelete && has:
+			tags = slices.DeleteFunc(tags, func(t upapi.ResourceIdentifier) bool { return t.ID == tag.ID })
+		}
+	}
+	xact.Relationships.Tags.Data = tags
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listTags(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	seen := make(map[string]bool)
+	for _, xacts := range s.fixtures.Transactions {
+		for _, x := range xacts {
+			for _, id := range x.TagIDs() {
+				seen[id] = true
+			}
+		}
+	}
+	s.mtx.Unlock()
+
+	var tags []upapi.Tag
+	for _, id := range slices.Sorted(maps.Keys(seen)) {
+		tags = append(tags, upapi.Tag{Type: upapi.ResourceTypeTags, ID: id})
+	}
+
+	writePage(w, r, tags)
+}
+
+// transaction finds a transaction by ID so it can be modified in place. The caller must hold s.mtx
+func (s *Server) transaction(id string) *upapi.Transaction {
+	for _, xacts := range s.fixtures.Transactions {
+		for i := range xacts {
+			if xacts[i].ID == id {
+				return &xacts[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) listCategories(w http.ResponseWriter, r *http.Request) {
+	parent := r.URL.Query().Get("filter[parent]")
+
+	s.mtx.Lock()
+	categories := []upapi.Category{}
+	for _, cat := range s.fixtures.Categories {
+		if parent == "" || cat.ParentID() == parent {
+			categories = append(categories, cat)
+		}
+	}
+	s.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, upapi.Response[[]upapi.Category]{Data: categories})
+}
+
+func (s *Server) getCategory(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	idx := slices.IndexFunc(s.fixtures.Categories, func(cat upapi.Category) bool { return cat.ID == r.PathValue("id") })
+	var cat upapi.Category
+	if idx != -1 {
+		cat = s.fixtures.Categories[idx]
+	}
+	s.mtx.Unlock()
+
+	if idx == -1 {
+		writeError(w, http.StatusNotFound, "The resource you requested could not be found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, upapi.Response[upapi.Category]{Data: cat})
+}
+
+func (s *Server) account(id string) (upapi.Account, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	idx := slices.IndexFunc(s.fixtures.Accounts, func(acc upapi.Account) bool { return acc.ID == id })
+	if idx == -1 {
+		return upapi.Account{}, false
+	}
+
+	return s.fixtures.Accounts[idx], true
+}
+
+// writePage writes a single page of items, using page[size] and page[after] from the request. Cursors are just the
+// offset of the next item, which real clients should treat as opaque anyway
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	q := r.URL.Query()
+
+	size := defaultPageSize
+	if v := q.Get("page[size]"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "page[size] must be a positive integer")
+			return
+		}
+		size = n
+	}
+
+	offset := 0
+	if v := q.Get("page[after]"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "page[after] is not a valid cursor")
+			return
+		}
+		offset = min(n, len(items))
+	}
+
+	end := min(offset+size, len(items))
+	data := items[offset:end]
+	if data == nil {
+		data = []T{}
+	}
+	resp := upapi.Response[[]T]{Data: data}
+
+	if end < len(items) {
+		next := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+		nq := r.URL.Query()
+		nq.Set("page[after]", strconv.Itoa(end))
+		next.RawQuery = nq.Encode()
+
+		nextStr := next.String()
+		resp.Links.Next = &nextStr
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	resp := upapi.ErrorResponse{Errors: []upapi.ErrorObject{{
+		Status: strconv.Itoa(status),
+		Title:  http.StatusText(status),
+		Detail: detail,
+	}}}
+
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}